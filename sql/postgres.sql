@@ -0,0 +1,59 @@
+CREATE TABLE sync_files (
+  id SERIAL PRIMARY KEY,
+  uri varchar(1024) DEFAULT NULL,
+  md5 varchar(50) DEFAULT NULL,
+  dest varchar(10) DEFAULT NULL,
+  status int DEFAULT 0,
+  at int NOT NULL DEFAULT 0,
+  attempts int NOT NULL DEFAULT 0,
+  owner varchar(64) DEFAULT NULL,
+  lease int NOT NULL DEFAULT 0,
+  priority int NOT NULL DEFAULT 0,
+  time int DEFAULT NULL
+);
+CREATE INDEX sync_files_uri ON sync_files (uri);
+CREATE INDEX sync_files_status ON sync_files (status);
+
+CREATE TABLE sync_files_targets (
+  id SERIAL PRIMARY KEY,
+  file_id int NOT NULL,
+  target varchar(64) NOT NULL,
+  status int NOT NULL DEFAULT 0,
+  attempts int NOT NULL DEFAULT 0,
+  error varchar(1024) DEFAULT NULL,
+  finished int NOT NULL DEFAULT 0,
+  UNIQUE (file_id, target)
+);
+
+CREATE TABLE sync_leader (
+  name varchar(64) PRIMARY KEY,
+  owner varchar(64) NOT NULL DEFAULT '',
+  lease int NOT NULL DEFAULT 0
+);
+
+CREATE TABLE sync_audit (
+  id SERIAL PRIMARY KEY,
+  task varchar(128) NOT NULL,
+  path varchar(1024) NOT NULL,
+  target varchar(64) NOT NULL,
+  address varchar(64) NOT NULL,
+  bytes bigint NOT NULL DEFAULT 0,
+  duration int NOT NULL DEFAULT 0,
+  result varchar(16) NOT NULL,
+  error varchar(1024) DEFAULT NULL,
+  time int NOT NULL DEFAULT 0
+);
+CREATE INDEX sync_audit_path ON sync_audit (path);
+
+-- 以下触发器对应默认配置(表sync_files channel sync_files status列 待同步状态0) 修改了table,channel或status配置时需同步修改
+-- 或执行 jzRedisRsync -config config.xml -sql postgres 按配置生成
+CREATE OR REPLACE FUNCTION sync_files_notify() RETURNS trigger AS $$
+BEGIN
+  PERFORM pg_notify('sync_files', NEW.id::text);
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- 只在新增或重置为待同步时通知 同步过程中的状态及租约回写不触发
+CREATE TRIGGER sync_files_notify AFTER INSERT OR UPDATE OF status ON sync_files
+  FOR EACH ROW WHEN (NEW.status = 0) EXECUTE PROCEDURE sync_files_notify();
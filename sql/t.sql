@@ -1,9 +1,53 @@
 CREATE TABLE `sync_files` (
   `id` int(20) NOT NULL AUTO_INCREMENT,
-  `file` varchar(1024) DEFAULT NULL,
+  `uri` varchar(1024) DEFAULT NULL,
   `md5` varchar(50) DEFAULT NULL,
   `dest` varchar(10) DEFAULT NULL,
-  `status` int(20) DEFAULT NULL COMMENT '0--默认  200--已经同步 404--文件不存在 412--文件本地校验失败 500--目标服务器发生错误',
+  `status` int(11) DEFAULT '0' COMMENT '0--默认  102--同步中 200--已经同步 206--部分目标同步失败 404--文件不存在或本地md5校验失败 410--重试次数超过上限 499--已取消 500--目标服务器发生错误',
+  `at` int(11) NOT NULL DEFAULT '0',
+  `attempts` int(11) NOT NULL DEFAULT '0',
+  `owner` varchar(64) DEFAULT NULL,
+  `lease` int(11) NOT NULL DEFAULT '0',
+  `priority` int(11) NOT NULL DEFAULT '0' COMMENT '值越大越先同步',
   `time` int(11) DEFAULT NULL,
-  PRIMARY KEY (`id`)
-) ENGINE=MyISAM AUTO_INCREMENT=1 DEFAULT CHARSET=utf8
\ No newline at end of file
+  PRIMARY KEY (`id`),
+  KEY `uri` (`uri`),
+  KEY `status` (`status`)
+) ENGINE=MyISAM AUTO_INCREMENT=1 DEFAULT CHARSET=utf8
+;
+
+CREATE TABLE `sync_files_targets` (
+  `id` int(20) NOT NULL AUTO_INCREMENT,
+  `file_id` int(20) NOT NULL,
+  `target` varchar(64) NOT NULL,
+  `status` int(11) NOT NULL DEFAULT '0' COMMENT '200--已经同步 500--目标服务器发生错误',
+  `attempts` int(11) NOT NULL DEFAULT '0',
+  `error` varchar(1024) DEFAULT NULL,
+  `finished` int(11) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `file_target` (`file_id`,`target`)
+) ENGINE=MyISAM AUTO_INCREMENT=1 DEFAULT CHARSET=utf8
+;
+
+CREATE TABLE `sync_leader` (
+  `name` varchar(64) NOT NULL,
+  `owner` varchar(64) NOT NULL DEFAULT '',
+  `lease` int(11) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8
+;
+
+CREATE TABLE `sync_audit` (
+  `id` int(20) NOT NULL AUTO_INCREMENT,
+  `task` varchar(128) NOT NULL,
+  `path` varchar(1024) NOT NULL,
+  `target` varchar(64) NOT NULL,
+  `address` varchar(64) NOT NULL,
+  `bytes` bigint(20) NOT NULL DEFAULT '0',
+  `duration` int(11) NOT NULL DEFAULT '0' COMMENT '毫秒',
+  `result` varchar(16) NOT NULL COMMENT 'ok--成功 failed--失败',
+  `error` varchar(1024) DEFAULT NULL,
+  `time` int(11) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`id`),
+  KEY `path` (`path`(255))
+) ENGINE=MyISAM AUTO_INCREMENT=1 DEFAULT CHARSET=utf8
@@ -0,0 +1,74 @@
+package jz
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// 需要管理权限的命令
+var adminCommands = []string{"sync", "retry", "cancel", "flush", "target", "config"}
+
+// 首个参数为目标组的命令
+var groupCommands = []string{"set", "setex", "setmulti"}
+
+type JzAuthUser struct {
+	Name     string `xml:"name"`
+	Password string `xml:"password"`
+	Groups   string `xml:"groups"`
+	Admin    bool   `xml:"admin"`
+}
+
+type JzAuthConfig struct {
+	Password string       `xml:"password"`
+	Users    []JzAuthUser `xml:"users>user"`
+}
+
+func (c *JzAuthConfig) Enabled() bool {
+	return len(c.Password) > 0 || len(c.Users) > 0
+}
+
+// auth password 或 auth username password 失败返回nil
+func (c *JzAuthConfig) Authenticate(args []string) *JzAuthUser {
+	equal := func(a, b string) bool {
+		return len(b) > 0 && subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+	}
+
+	switch len(args) {
+	case 1:
+		if equal(args[0], c.Password) {
+			return &JzAuthUser{Name: "default", Admin: true}
+		}
+	case 2:
+		for i := range c.Users {
+			if c.Users[i].Name == args[0] && equal(args[1], c.Users[i].Password) {
+				return &c.Users[i]
+			}
+		}
+	}
+
+	return nil
+}
+
+// 校验用户是否可执行命令 groups为空时可同步到所有目标组
+func (u *JzAuthUser) Allow(args []string) error {
+	command := strings.ToLower(args[0])
+
+	if InStringArray(command, adminCommands) && !u.Admin {
+		return errors.New(fmt.Sprintf("user %s has no permissions to run the '%s' command", u.Name, command))
+	}
+
+	if !InStringArray(command, groupCommands) || len(args) < 2 || len(u.Groups) == 0 {
+		return nil
+	}
+
+	groups := strings.Split(strings.ToUpper(u.Groups), ",")
+	for _, hn := range strings.Split(strings.ToUpper(args[1]), ",") {
+		if !InStringArray(hn, groups) {
+			return errors.New(fmt.Sprintf("user %s has no permissions to send to '%s'", u.Name, hn))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package jz
+
+import (
+	"testing"
+)
+
+func TestJzAuthUserAllow(t *testing.T) {
+	admin := &JzAuthUser{Name: "root", Admin: true}
+	deploy := &JzAuthUser{Name: "deploy", Groups: "s1,S2"}
+	anyGroup := &JzAuthUser{Name: "viewer"}
+
+	cases := []struct {
+		user   *JzAuthUser
+		args   []string
+		expect bool
+	}{
+		{admin, []string{"FLUSH"}, true},
+		{admin, []string{"set", "*", "a.jpg"}, true},
+		{deploy, []string{"get", "mysql:1"}, true},
+		{deploy, []string{"subscribe", "task:done"}, true},
+		{deploy, []string{"set", "S1", "a.jpg"}, true},
+		{deploy, []string{"SETEX", "s1,s2", "a.jpg", "md5"}, true},
+		{deploy, []string{"setmulti", "S1,S3", "a.jpg"}, false},
+		{deploy, []string{"set", "*", "a.jpg"}, false},
+		{deploy, []string{"set"}, true},
+		{deploy, []string{"target", "pause", "S1"}, false},
+		{deploy, []string{"Config", "reload"}, false},
+		{anyGroup, []string{"set", "*", "a.jpg"}, true},
+		{anyGroup, []string{"retry", "mysql", "1"}, false},
+	}
+
+	for _, c := range cases {
+		err := c.user.Allow(c.args)
+		if (err == nil) != c.expect {
+			t.Errorf("%s Allow(%v) = %v, expect allowed %v", c.user.Name, c.args, err, c.expect)
+		}
+	}
+}
+
+func TestJzAuthConfigAuthenticate(t *testing.T) {
+	config := &JzAuthConfig{
+		Password: "secret",
+		Users:    []JzAuthUser{{Name: "deploy", Password: "pw"}, {Name: "nopass"}},
+	}
+
+	cases := []struct {
+		args   []string
+		expect string
+	}{
+		{[]string{"secret"}, "default"},
+		{[]string{"wrong"}, ""},
+		{[]string{"deploy", "pw"}, "deploy"},
+		{[]string{"deploy", "secret"}, ""},
+		{[]string{"nopass", ""}, ""},
+		{[]string{}, ""},
+	}
+
+	for _, c := range cases {
+		user := config.Authenticate(c.args)
+		name := ""
+		if user != nil {
+			name = user.Name
+		}
+
+		if name != c.expect {
+			t.Errorf("Authenticate(%v) = %s, expect %s", c.args, name, c.expect)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package jz
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 单次同步尝试的记录
+type JzAuditRecord struct {
+	Task     string `json:"task"`
+	Path     string `json:"path"`
+	Target   string `json:"target"`
+	Address  string `json:"address"`
+	Bytes    int64  `json:"bytes"`
+	Duration int64  `json:"duration"`
+	Result   string `json:"result"`
+	Error    string `json:"error"`
+	Time     int64  `json:"time"`
+}
+
+type JzAuditor interface {
+	Record(r *JzAuditRecord) error
+	History(file string, limit int) ([]*JzAuditRecord, error)
+	Close()
+}
+
+func NewJzAuditor(config *JzAuditConfig, sources []TaskSource) (JzAuditor, error) {
+	switch strings.ToLower(config.Type) {
+	case "file":
+		return NewJzFileAuditor(config.File)
+	case "db":
+		for _, source := range sources {
+			if s, ok := source.(DaoTaskSource); ok && source.Name() == config.Source {
+				return &JzDbAuditor{dao: s.Dao(), table: config.Table}, nil
+			}
+		}
+		return nil, errors.New(fmt.Sprintf("task source %s not support audit", config.Source))
+	}
+
+	return nil, errors.New(fmt.Sprintf("unknown audit type %s", config.Type))
+}
+
+func AuditPath(file string) string {
+	return strings.TrimPrefix(path.Clean("/"+file), "/")
+}
+
+// 记录一次同步尝试 未配置audit时忽略
+func Audit(t *JzTask, target *JzTargetServer, bytes int64, duration time.Duration, ok bool, err error) {
+	if GlobalData.Auditor == nil {
+		return
+	}
+
+	r := &JzAuditRecord{
+		Task:     t.Key(),
+		Path:     AuditPath(path.Join(t.RelativePath, t.Name)),
+		Target:   target.Name,
+		Address:  target.Address,
+		Bytes:    bytes,
+		Duration: int64(duration / time.Millisecond),
+		Result:   "ok",
+		Time:     time.Now().Unix(),
+	}
+
+	if !ok {
+		r.Result = "failed"
+		if err != nil {
+			r.Error = err.Error()
+		}
+	}
+
+	if e := GlobalData.Auditor.Record(r); e != nil {
+		JzLogger.Printf("audit task %s to %s failed %v", r.Task, r.Target, e)
+	}
+}
+
+// 以json行追加到本地文件
+type JzFileAuditor struct {
+	sync.Mutex
+	file string
+	f    *os.File
+}
+
+func NewJzFileAuditor(file string) (*JzFileAuditor, error) {
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &JzFileAuditor{file: file, f: f}, nil
+}
+
+func (obj *JzFileAuditor) Record(r *JzAuditRecord) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	obj.Lock()
+	defer obj.Unlock()
+
+	_, err = obj.f.Write(append(data, '\n'))
+
+	return err
+}
+
+// 从文件末尾向前查找 取到limit条后停止
+func (obj *JzFileAuditor) History(file string, limit int) ([]*JzAuditRecord, error) {
+	result := make([]*JzAuditRecord, 0)
+	if limit <= 0 {
+		return result, nil
+	}
+
+	f, err := os.Open(obj.file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	file = AuditPath(file)
+
+	//与写入时相同的json编码 路径中的特殊字符会被转义
+	quoted, err := json.Marshal(file)
+	if err != nil {
+		return nil, err
+	}
+	needle := append([]byte(`"path":`), quoted...)
+
+	err = readLinesReverse(f, func(line []byte) bool {
+		if !bytes.Contains(line, needle) {
+			return true
+		}
+
+		r := &JzAuditRecord{}
+		if json.Unmarshal(line, r) != nil || r.Path != file {
+			return true
+		}
+
+		result = append(result, r)
+
+		return len(result) < limit
+	})
+
+	return result, err
+}
+
+// 按块从文件末尾向前逐行读取 回调返回false时停止
+func readLinesReverse(f *os.File, fn func(line []byte) bool) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	offset := fi.Size()
+	rest := make([]byte, 0)
+	for offset > 0 {
+		n := int64(64 * 1024)
+		if offset < n {
+			n = offset
+		}
+		offset -= n
+
+		buf := make([]byte, n, int(n)+len(rest))
+		if _, err := f.ReadAt(buf, offset); err != nil {
+			return err
+		}
+		buf = append(buf, rest...)
+
+		for {
+			i := bytes.LastIndexByte(buf, '\n')
+			if i < 0 {
+				break
+			}
+
+			line := buf[i+1:]
+			buf = buf[:i]
+			if len(line) > 0 && !fn(line) {
+				return nil
+			}
+		}
+
+		//跨块的行与前一块拼接
+		rest = buf
+	}
+
+	if len(rest) > 0 {
+		fn(rest)
+	}
+
+	return nil
+}
+
+func (obj *JzFileAuditor) Close() {
+	obj.Lock()
+	defer obj.Unlock()
+
+	obj.f.Close()
+}
+
+// 写入任务来源所在数据库的表
+type JzDbAuditor struct {
+	dao   *JzDao
+	table string
+}
+
+func (obj *JzDbAuditor) Record(r *JzAuditRecord) error {
+	_, err := obj.dao.db.Exec(obj.dao.rebind(fmt.Sprintf("insert into %s (task,path,target,address,bytes,duration,result,error,time) values (?,?,?,?,?,?,?,?,?)", obj.table)),
+		r.Task, r.Path, r.Target, r.Address, r.Bytes, r.Duration, r.Result, r.Error, r.Time)
+
+	return err
+}
+
+func (obj *JzDbAuditor) History(file string, limit int) ([]*JzAuditRecord, error) {
+	rows, err := obj.dao.db.Query(obj.dao.rebind(fmt.Sprintf("select task,path,target,address,bytes,duration,result,error,time from %s where path=? order by id desc limit %d", obj.table, limit)),
+		AuditPath(file))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]*JzAuditRecord, 0)
+	for rows.Next() {
+		r := &JzAuditRecord{}
+		var e sql.NullString
+		err := rows.Scan(&r.Task, &r.Path, &r.Target, &r.Address, &r.Bytes, &r.Duration, &r.Result, &e, &r.Time)
+		if err != nil {
+			return nil, err
+		}
+		r.Error = e.String
+		result = append(result, r)
+	}
+
+	return result, nil
+}
+
+// 数据库连接由任务来源关闭
+func (obj *JzDbAuditor) Close() {
+}
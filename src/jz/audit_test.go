@@ -0,0 +1,67 @@
+package jz
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestJzFileAuditorHistory(t *testing.T) {
+	dir := setupTestConfig(t)
+
+	auditor, err := NewJzFileAuditor(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditor.Close()
+
+	//记录跨越多个读取块 路径含json转义字符
+	files := []string{"a&b/<x>.jpg", "a.jpg", "b.jpg"}
+	for i := 0; i < 3000; i++ {
+		r := &JzAuditRecord{
+			Task:   fmt.Sprintf("t:%d", i),
+			Path:   files[i%len(files)],
+			Target: "A",
+			Result: "ok",
+			Error:  strings.Repeat("x", i%50),
+			Time:   int64(i),
+		}
+		if err := auditor.Record(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		file   string
+		limit  int
+		expect []int64
+	}{
+		{"a&b/<x>.jpg", 3, []int64{2997, 2994, 2991}},
+		{"/a.jpg", 2, []int64{2998, 2995}},
+		{"b.jpg", 1, []int64{2999}},
+		{"c.jpg", 5, []int64{}},
+		{"a.jpg", 0, []int64{}},
+	}
+
+	for _, c := range cases {
+		records, err := auditor.History(c.file, c.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		times := make([]int64, 0)
+		for _, r := range records {
+			times = append(times, r.Time)
+		}
+
+		if fmt.Sprint(times) != fmt.Sprint(c.expect) {
+			t.Errorf("History(%s, %d) = %v, expect %v", c.file, c.limit, times, c.expect)
+		}
+	}
+
+	records, err := auditor.History("a&b/<x>.jpg", 2000)
+	if err != nil || len(records) != 1000 || records[999].Time != 0 {
+		t.Errorf("expect all 1000 records, got %d %v", len(records), err)
+	}
+}
@@ -0,0 +1,268 @@
+package jz
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-mysql-org/go-mysql/canal"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"io/ioutil"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// 消费任务表的binlog 新增及更新的记录立刻拉取 不可用时回退为轮询
+type JzBinlog struct {
+	canal.DummyEventHandler
+	sync.Mutex
+	dao        *JzDao
+	rsync      *JzRsync
+	checkpoint string
+	canal      *canal.Canal
+	running    bool
+	stopped    chan bool
+	exited     chan bool
+}
+
+func NewJzBinlog(dao *JzDao, rsync *JzRsync) *JzBinlog {
+	checkpoint := dao.config.Binlog.Checkpoint
+	if len(checkpoint) == 0 {
+		checkpoint = fmt.Sprintf("./%s.binlog.pos", dao.name)
+	}
+
+	return &JzBinlog{
+		dao:        dao,
+		rsync:      rsync,
+		checkpoint: checkpoint,
+		stopped:    make(chan bool),
+		exited:     make(chan bool),
+	}
+}
+
+func (obj *JzBinlog) String() string {
+	return fmt.Sprintf("binlog-%s", obj.dao.name)
+}
+
+func (obj *JzBinlog) Running() bool {
+	obj.Lock()
+	defer obj.Unlock()
+
+	return obj.running
+}
+
+func (obj *JzBinlog) setRunning(running bool) {
+	obj.Lock()
+	defer obj.Unlock()
+
+	obj.running = running
+}
+
+func (obj *JzBinlog) Start() {
+	go func() {
+		defer close(obj.exited)
+
+		for {
+			err := obj.run()
+			obj.setRunning(false)
+
+			select {
+			case <-obj.stopped:
+				return
+			default:
+			}
+
+			JzLogger.Printf("%s unavailable fallback to polling %v", obj, err)
+			obj.rsync.Pull()
+
+			select {
+			case <-obj.stopped:
+				return
+			case <-time.After(time.Minute):
+			}
+		}
+	}()
+}
+
+func (obj *JzBinlog) run() error {
+	config := obj.dao.config
+
+	cfg := canal.NewDefaultConfig()
+	cfg.Addr = fmt.Sprintf("%s:%d", config.Ip, config.Port)
+	cfg.User = config.Username
+	cfg.Password = config.Password
+	cfg.ServerID = config.Binlog.ServerId
+	cfg.Flavor = "mysql"
+	cfg.IncludeTableRegex = []string{fmt.Sprintf("^%s\\.%s$", regexp.QuoteMeta(config.Database), regexp.QuoteMeta(config.Table.Name))}
+	cfg.Dump.ExecutionPath = ""
+
+	c, err := canal.NewCanal(cfg)
+	if err != nil {
+		return err
+	}
+	c.SetEventHandler(obj)
+
+	pos, err := obj.loadPosition()
+	if err != nil {
+		pos, err = c.GetMasterPos()
+		if err != nil {
+			c.Close()
+			return err
+		}
+	}
+
+	obj.Lock()
+	select {
+	case <-obj.stopped:
+		obj.Unlock()
+		c.Close()
+		return nil
+	default:
+	}
+	obj.canal = c
+	obj.running = true
+	obj.Unlock()
+
+	JzLogger.Printf("%s start from %s:%d", obj, pos.Name, pos.Pos)
+
+	done := make(chan bool)
+	go func() {
+		interval := time.NewTicker(time.Second * time.Duration(3))
+		defer interval.Stop()
+
+		for {
+			select {
+			case <-done:
+				obj.savePosition(c.SyncedPosition())
+				return
+			case <-interval.C:
+				obj.savePosition(c.SyncedPosition())
+			}
+		}
+	}()
+
+	err = c.RunFrom(pos)
+	close(done)
+
+	obj.Lock()
+	obj.canal = nil
+	obj.Unlock()
+
+	return err
+}
+
+func (obj *JzBinlog) Stop() {
+	obj.Lock()
+	close(obj.stopped)
+	c := obj.canal
+	obj.Unlock()
+
+	if c != nil {
+		c.Close()
+	}
+
+	<-obj.exited
+	JzLogger.Printf("%s stopped", obj)
+}
+
+func (obj *JzBinlog) OnRow(e *canal.RowsEvent) error {
+	if e.Action != canal.InsertAction && e.Action != canal.UpdateAction {
+		return nil
+	}
+
+	idx := e.Table.FindColumn(obj.dao.table.Columns.Id)
+	if idx < 0 {
+		return nil
+	}
+
+	//update事件中每两行为更新前后的数据
+	step := 1
+	if e.Action == canal.UpdateAction {
+		step = 2
+	}
+
+	ids := make([]int, 0)
+	for i := step - 1; i < len(e.Rows); i += step {
+		if idx >= len(e.Rows[i]) {
+			continue
+		}
+
+		id, ok := binlogInt(e.Rows[i][idx])
+		if ok {
+			ids = append(ids, id)
+		}
+	}
+
+	tasks, err := obj.dao.GetPendingTasksByIds(ids)
+	if err != nil {
+		JzLogger.Printf("%s pull tasks failed %v", obj, err)
+		return nil
+	}
+
+	for _, t := range tasks {
+		obj.rsync.Send(t)
+	}
+
+	//尚未到期的定时任务 到期后拉取
+	at, err := obj.dao.NextScheduled(ids)
+	if err != nil {
+		JzLogger.Printf("%s get scheduled tasks failed %v", obj, err)
+		return nil
+	}
+
+	if at > 0 {
+		time.AfterFunc(time.Until(time.Unix(at, 0)), obj.rsync.Pull)
+	}
+
+	return nil
+}
+
+func (obj *JzBinlog) loadPosition() (mysql.Position, error) {
+	pos := mysql.Position{}
+
+	data, err := ioutil.ReadFile(obj.checkpoint)
+	if err != nil {
+		return pos, err
+	}
+
+	err = json.Unmarshal(data, &pos)
+
+	return pos, err
+}
+
+func (obj *JzBinlog) savePosition(pos mysql.Position) {
+	if len(pos.Name) == 0 {
+		return
+	}
+
+	data, _ := json.Marshal(pos)
+
+	err := WriteFileAtomic(obj.checkpoint, data)
+	if err != nil {
+		JzLogger.Printf("%s save position %s failed %v", obj, obj.checkpoint, err)
+	}
+}
+
+func binlogInt(v interface{}) (int, bool) {
+	switch n := v.(type) {
+	case int8:
+		return int(n), true
+	case int16:
+		return int(n), true
+	case int32:
+		return int(n), true
+	case int64:
+		return int(n), true
+	case uint8:
+		return int(n), true
+	case uint16:
+		return int(n), true
+	case uint32:
+		return int(n), true
+	case uint64:
+		return int(n), true
+	case int:
+		return n, true
+	}
+
+	return 0, false
+}
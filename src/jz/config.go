@@ -6,6 +6,7 @@ import (
 	"encoding/xml"
 	"fmt"
 	"strings"
+	"sync"
 )
 
 type TagetGroups []string
@@ -30,25 +31,292 @@ type JzTargetServer struct {
 	Address string `xml:"address"`
 }
 
+// 按顺序比较目标配置 空分组与未配置分组相同
+func SameTargets(a []JzTargetServer, b []JzTargetServer) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i].Name != b[i].Name || a[i].Address != b[i].Address || a[i].Group.ToString() != b[i].Group.ToString() {
+			return false
+		}
+	}
+
+	return true
+}
+
+type JzBinlogConfig struct {
+	ServerId uint32 `xml:"serverid"`
+	Checkpoint string `xml:"checkpoint"`
+}
+
+type JzTableColumns struct {
+	Id string `xml:"id"`
+	Uri string `xml:"uri"`
+	Md5 string `xml:"md5"`
+	Dest string `xml:"dest"`
+	Status string `xml:"status"`
+	At string `xml:"at"`
+	Attempts string `xml:"attempts"`
+	Owner string `xml:"owner"`
+	Lease string `xml:"lease"`
+	Priority string `xml:"priority"`
+}
+
+type JzTargetColumns struct {
+	FileId string `xml:"fileid"`
+	Target string `xml:"target"`
+	Status string `xml:"status"`
+	Attempts string `xml:"attempts"`
+	Error string `xml:"error"`
+	Finished string `xml:"finished"`
+}
+
+type JzTableStatus struct {
+	Pending int `xml:"pending"`
+	Done int `xml:"done"`
+	NotFound int `xml:"notfound"`
+	Failed int `xml:"failed"`
+	Partial int `xml:"partial"`
+	Dead int `xml:"dead"`
+	InProgress int `xml:"inprogress"`
+	Cancelled int `xml:"cancelled"`
+	present map[string]bool
+}
+
+// 记录xml中配置了的状态 配置为0时也不使用默认值
+func (s *JzTableStatus) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var v struct {
+		Pending    *int `xml:"pending"`
+		Done       *int `xml:"done"`
+		NotFound   *int `xml:"notfound"`
+		Failed     *int `xml:"failed"`
+		Partial    *int `xml:"partial"`
+		Dead       *int `xml:"dead"`
+		InProgress *int `xml:"inprogress"`
+		Cancelled  *int `xml:"cancelled"`
+	}
+
+	err := d.DecodeElement(&v, &start)
+	if err != nil {
+		return err
+	}
+
+	s.present = make(map[string]bool)
+	for name, f := range map[string]struct {
+		value  *int
+		status *int
+	}{
+		"pending":    {v.Pending, &s.Pending},
+		"done":       {v.Done, &s.Done},
+		"notfound":   {v.NotFound, &s.NotFound},
+		"failed":     {v.Failed, &s.Failed},
+		"partial":    {v.Partial, &s.Partial},
+		"dead":       {v.Dead, &s.Dead},
+		"inprogress": {v.InProgress, &s.InProgress},
+		"cancelled":  {v.Cancelled, &s.Cancelled},
+	} {
+		if f.value != nil {
+			*f.status = *f.value
+			s.present[name] = true
+		}
+	}
+
+	return nil
+}
+
+func (s *JzTableStatus) values() map[string]int {
+	return map[string]int{
+		"pending":    s.Pending,
+		"done":       s.Done,
+		"notfound":   s.NotFound,
+		"failed":     s.Failed,
+		"partial":    s.Partial,
+		"dead":       s.Dead,
+		"inprogress": s.InProgress,
+		"cancelled":  s.Cancelled,
+	}
+}
+
+// 各状态值不可重复 否则无法区分记录的状态
+func (s *JzTableStatus) Validate() error {
+	values := s.values()
+	names := make(map[int]string)
+	for _, name := range []string{"pending", "done", "notfound", "failed", "partial", "dead", "inprogress", "cancelled"} {
+		value := values[name]
+		if other, ok := names[value]; ok {
+			return errors.New(fmt.Sprintf("duplicate status value %d for %s and %s", value, other, name))
+		}
+		names[value] = name
+	}
+
+	return nil
+}
+
+type JzTableConfig struct {
+	Name string `xml:"name"`
+	Targets string `xml:"targets"`
+	Columns JzTableColumns `xml:"columns"`
+	TargetColumns JzTargetColumns `xml:"targetcolumns"`
+	Status JzTableStatus `xml:"status"`
+}
+
+func (c *JzTableConfig) SetDefaults() {
+	defaultString := func(v *string, d string) {
+		if len(*v) == 0 {
+			*v = d
+		}
+	}
+
+	//未在xml中配置且为0时使用默认值
+	defaultInt := func(v *int, name string, d int) {
+		if *v == 0 && !c.Status.present[name] {
+			*v = d
+		}
+	}
+
+	defaultString(&c.Name, "sync_files")
+	defaultString(&c.Columns.Id, "id")
+	defaultString(&c.Columns.Uri, "uri")
+	defaultString(&c.Columns.Md5, "md5")
+	defaultString(&c.Columns.Dest, "dest")
+	defaultString(&c.Columns.Status, "status")
+	defaultString(&c.Columns.At, "at")
+	defaultString(&c.Columns.Attempts, "attempts")
+	defaultString(&c.Columns.Owner, "owner")
+	defaultString(&c.Columns.Lease, "lease")
+	defaultString(&c.TargetColumns.FileId, "file_id")
+	defaultString(&c.TargetColumns.Target, "target")
+	defaultString(&c.TargetColumns.Status, "status")
+	defaultString(&c.TargetColumns.Attempts, "attempts")
+	defaultString(&c.TargetColumns.Error, "error")
+	defaultString(&c.TargetColumns.Finished, "finished")
+	defaultInt(&c.Status.Done, "done", 200)
+	defaultInt(&c.Status.NotFound, "notfound", 404)
+	defaultInt(&c.Status.Failed, "failed", 500)
+	defaultInt(&c.Status.Partial, "partial", 206)
+	defaultInt(&c.Status.Dead, "dead", 410)
+	defaultInt(&c.Status.InProgress, "inprogress", 102)
+	defaultInt(&c.Status.Cancelled, "cancelled", 499)
+}
+
+type JzRetryConfig struct {
+	Max int `xml:"max"`
+	Base int `xml:"base"`
+	MaxDelay int `xml:"maxdelay"`
+}
+
+func (c *JzRetryConfig) SetDefaults() {
+	if c.Base <= 0 {
+		c.Base = 30
+	}
+
+	if c.MaxDelay <= 0 {
+		c.MaxDelay = 3600
+	}
+}
+
 type JzMysqlConfig struct {
 	Ip string `xml:"ip"`
 	Username string `xml:"username"`
 	Password string `xml:"password"`
 	Port uint `xml:"port"`
 	Database string `xml:"database"`
+	Binlog JzBinlogConfig `xml:"binlog"`
+	Table JzTableConfig `xml:"table"`
+	Retry JzRetryConfig `xml:"retry"`
+	Cursor string `xml:"cursor"`
+	Lease int `xml:"lease"`
+}
+
+type JzWatchRule struct {
+	Match string `xml:"match"`
+	Dest string `xml:"dest"`
+}
+
+type JzSourceConfig struct {
+	Name string `xml:"name"`
+	Type string `xml:"type"`
+	Path string `xml:"path"`
+	Channel string `xml:"channel"`
+	Sslmode string `xml:"sslmode"`
+	Address string `xml:"address"`
+	Stream string `xml:"stream"`
+	Group string `xml:"group"`
+	Consumer string `xml:"consumer"`
+	Idle int `xml:"idle"`
+	Deliveries int `xml:"deliveries"`
+	Dead string `xml:"dead"`
+	Delay int `xml:"delay"`
+	Rules []JzWatchRule `xml:"rules>rule"`
+	JzMysqlConfig
+}
+
+type JzLeaderConfig struct {
+	Source string `xml:"source"`
+	Table string `xml:"table"`
+	Name string `xml:"name"`
+	Lease int `xml:"lease"`
+}
+
+type JzAuditConfig struct {
+	Type string `xml:"type"`
+	File string `xml:"file"`
+	Source string `xml:"source"`
+	Table string `xml:"table"`
 }
 
 type JzRsyncConfig struct {
 	Address string `xml:"address"`
+	Socket string `xml:"socket"`
+	Auth JzAuthConfig `xml:"auth"`
+	Instance string `xml:"instance"`
 	Repertory string `xml:"repertory"`
 	Interval int `xml:"interval"`
+	Fairness int `xml:"fairness"`
+	Batch int `xml:"batch"`
 	TargetServer []JzTargetServer `xml:"target>server"`
 	MysqlConfig JzMysqlConfig `xml:"mysql"`
+	PostgresConfig JzSourceConfig `xml:"postgres"`
+	Sources []JzSourceConfig `xml:"sources>source"`
+	Leader JzLeaderConfig `xml:"leader"`
+	Audit JzAuditConfig `xml:"audit"`
+	Schedule string `xml:"schedule"`
 }
 
 var jzRsyncConfig *JzRsyncConfig
+var jzRsyncConfigLock sync.RWMutex
+var jzRsyncConfigFile string
+
+// 运行中的配置 重新加载时整体替换 不可修改
+func RsyncConfig() *JzRsyncConfig {
+	jzRsyncConfigLock.RLock()
+	defer jzRsyncConfigLock.RUnlock()
+
+	return jzRsyncConfig
+}
+
+func setRsyncConfig(config *JzRsyncConfig) {
+	jzRsyncConfigLock.Lock()
+	jzRsyncConfig = config
+	jzRsyncConfigLock.Unlock()
+}
 
 func ParseXmlConfig(path string) (*JzRsyncConfig, error) {
+	config, err := LoadXmlConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	setRsyncConfig(config)
+	jzRsyncConfigFile = path
+
+	return config, nil
+}
+
+// 读取配置文件 不影响运行中的配置
+func LoadXmlConfig(path string) (*JzRsyncConfig, error) {
 	if len(path) == 0 {
 		return nil, errors.New("not found configure xml file")
 	}
@@ -64,7 +332,7 @@ func ParseXmlConfig(path string) (*JzRsyncConfig, error) {
 	}
 	defer f.Close()
 
-	jzRsyncConfig = &JzRsyncConfig{}
+	config := &JzRsyncConfig{}
 
 	data := make([]byte, n)
 
@@ -77,15 +345,100 @@ func ParseXmlConfig(path string) (*JzRsyncConfig, error) {
 		return nil, errors.New(fmt.Sprintf("expect read configure xml file size %d but result is %d", n, m))
 	}
 
-	err = xml.Unmarshal(data, &jzRsyncConfig)
+	err = xml.Unmarshal(data, &config)
 	if err != nil {
 		return nil, err
 	}
 
-	r, err := CheckFileIsDirectory(jzRsyncConfig.Repertory)
+	r, err := CheckFileIsDirectory(config.Repertory)
 	if !r {
 		return nil, err
 	}
 
-	return jzRsyncConfig, nil
+	if config.Batch <= 0 {
+		config.Batch = 1000
+	}
+
+	if config.Fairness == 0 {
+		config.Fairness = 10
+	}
+
+	//默认实例标识需在重启后保持不变 才能恢复上次未完成的任务
+	if len(config.Instance) == 0 {
+		hostname, _ := os.Hostname()
+		config.Instance = hostname
+		if len(config.Address) > 0 {
+			config.Instance = fmt.Sprintf("%s-%s", hostname, config.Address)
+		} else if len(config.Socket) > 0 {
+			config.Instance = fmt.Sprintf("%s-%s", hostname, config.Socket)
+		}
+	}
+
+	//兼容旧的<mysql>配置 作为名为mysql的任务来源
+	if len(config.MysqlConfig.Ip) > 0 {
+		config.Sources = append([]JzSourceConfig{{
+			Name:          "mysql",
+			Type:          "mysql",
+			JzMysqlConfig: config.MysqlConfig,
+		}}, config.Sources...)
+	}
+
+	if len(config.PostgresConfig.Ip) > 0 {
+		config.PostgresConfig.Name = "postgres"
+		config.PostgresConfig.Type = "postgres"
+		config.Sources = append([]JzSourceConfig{config.PostgresConfig}, config.Sources...)
+	}
+
+	sourceNames := make([]string, 0)
+	for i := range config.Sources {
+		s := &config.Sources[i]
+		s.Table.SetDefaults()
+		s.Retry.SetDefaults()
+
+		if err := s.Table.Status.Validate(); err != nil {
+			return nil, errors.New(fmt.Sprintf("task source %s %v", s.Name, err))
+		}
+
+		if len(s.Name) == 0 || len(s.Type) == 0 {
+			return nil, errors.New("task source name and type is required")
+		}
+
+		if InStringArray(s.Name, sourceNames) {
+			return nil, errors.New(fmt.Sprintf("duplicate task source name %s", s.Name))
+		}
+
+		sourceNames = append(sourceNames, s.Name)
+	}
+
+	if config.Audit.Type == "file" && len(config.Audit.File) == 0 {
+		config.Audit.File = "./audit.log"
+	}
+
+	if len(config.Schedule) == 0 {
+		config.Schedule = "./schedule.json"
+	}
+
+	if len(config.Audit.Table) == 0 {
+		config.Audit.Table = "sync_audit"
+	}
+
+	if len(config.Leader.Source) > 0 {
+		if !InStringArray(config.Leader.Source, sourceNames) {
+			return nil, errors.New(fmt.Sprintf("not found leader task source %s", config.Leader.Source))
+		}
+
+		if len(config.Leader.Table) == 0 {
+			config.Leader.Table = "sync_leader"
+		}
+
+		if len(config.Leader.Name) == 0 {
+			config.Leader.Name = "jzRedisRsync"
+		}
+
+		if config.Leader.Lease < 3 {
+			config.Leader.Lease = 15
+		}
+	}
+
+	return config, nil
 }
\ No newline at end of file
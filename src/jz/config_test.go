@@ -0,0 +1,62 @@
+package jz
+
+import (
+	"encoding/xml"
+	"reflect"
+	"testing"
+)
+
+func TestSameTargets(t *testing.T) {
+	a := JzTargetServer{Name: "A", Address: "127.0.0.1:1"}
+	b := JzTargetServer{Name: "B", Address: "127.0.0.1:2", Group: TagetGroups{"img"}}
+
+	cases := []struct {
+		x      []JzTargetServer
+		y      []JzTargetServer
+		expect bool
+	}{
+		{nil, []JzTargetServer{}, true},
+		{[]JzTargetServer{a, b}, []JzTargetServer{a, b}, true},
+		{[]JzTargetServer{{Name: "A", Address: "127.0.0.1:1", Group: TagetGroups{}}}, []JzTargetServer{a}, true},
+		{[]JzTargetServer{a}, []JzTargetServer{a, b}, false},
+		{[]JzTargetServer{a, b}, []JzTargetServer{b, a}, false},
+		{[]JzTargetServer{{Name: "A", Address: "127.0.0.1:3"}}, []JzTargetServer{a}, false},
+		{[]JzTargetServer{{Name: "B", Address: "127.0.0.1:2", Group: TagetGroups{"css"}}}, []JzTargetServer{b}, false},
+	}
+
+	for i, c := range cases {
+		if ok := SameTargets(c.x, c.y); ok != c.expect {
+			t.Errorf("case %d: SameTargets = %v, expect %v", i, ok, c.expect)
+		}
+	}
+}
+
+// xml中配置为0的状态不使用默认值 状态值不可重复
+func TestJzTableStatus(t *testing.T) {
+	cases := []struct {
+		xml    string
+		expect JzTableStatus
+		valid  bool
+	}{
+		{`<table></table>`, JzTableStatus{0, 200, 404, 500, 206, 410, 102, 499, nil}, true},
+		{`<table><status><pending>1</pending><done>0</done></status></table>`, JzTableStatus{1, 0, 404, 500, 206, 410, 102, 499, nil}, true},
+		{`<table><status><failed>0</failed></status></table>`, JzTableStatus{0, 200, 404, 0, 206, 410, 102, 499, nil}, false},
+		{`<table><status><dead>500</dead></status></table>`, JzTableStatus{0, 200, 404, 500, 206, 500, 102, 499, nil}, false},
+	}
+
+	for i, c := range cases {
+		var config JzTableConfig
+		if err := xml.Unmarshal([]byte(c.xml), &config); err != nil {
+			t.Fatal(err)
+		}
+		config.SetDefaults()
+
+		if values := config.Status.values(); !reflect.DeepEqual(values, c.expect.values()) {
+			t.Errorf("case %d: status = %v, expect %v", i, values, c.expect.values())
+		}
+
+		if err := config.Status.Validate(); (err == nil) != c.valid {
+			t.Errorf("case %d: validate = %v, expect valid %v", i, err, c.valid)
+		}
+	}
+}
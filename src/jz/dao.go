@@ -2,57 +2,432 @@ package jz
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	_ "github.com/go-sql-driver/mysql"
-	"path"
+	"io/ioutil"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 )
 
+// 拉取到的一条任务记录
+type jzTaskRecord struct {
+	id       int
+	uri      sql.NullString
+	md5      sql.NullString
+	dest     sql.NullString
+	status   sql.NullInt64
+	priority sql.NullInt64
+}
+
 type JzDao struct {
 	sync.Mutex
-	db *sql.DB
-	id int
+	name    string
+	driver  string
+	db      *sql.DB
+	id      int
+	config  *JzSourceConfig
+	table   *JzTableConfig
+	binlog  *JzBinlog
+	drained bool
+	owner   string
+	cursor  string
+	closed  chan bool
+	ahead   map[int]bool
+	retired bool
+
+	//已拉取未完成的任务 游标只保存到其中最小id之前 崩溃重启后重新拉取
+	//被替换后不再保存 游标文件由新的同名来源使用
+	cursorLock   sync.Mutex
+	head         int
+	saved        int
+	inflight     map[int]bool
+	cursorPaused bool
 }
 
-var jzDaoInstance *JzDao
-var once sync.Once
+func init() {
+	RegisterTaskSource("mysql", NewJzDao)
+}
 
-func JzDaoInstance() *JzDao {
-	once.Do(func() {
-		source := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s",
-			jzRsyncConfig.MysqlConfig.Username,
-			jzRsyncConfig.MysqlConfig.Password,
-			jzRsyncConfig.MysqlConfig.Ip,
-			jzRsyncConfig.MysqlConfig.Port,
-			jzRsyncConfig.MysqlConfig.Database,
-		)
+func NewJzDao(config *JzSourceConfig) (TaskSource, error) {
+	source := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s",
+		config.Username,
+		config.Password,
+		config.Ip,
+		config.Port,
+		config.Database,
+	)
 
-		JzLogger.Printf("start connect to mysql server %s", source)
+	JzLogger.Printf("start connect to mysql server %s", source)
 
-		db, err := sql.Open("mysql", source)
+	dao, err := openJzDao(config, "mysql", source)
+	if err != nil {
+		return nil, err
+	}
 
-		if err != nil {
-			JzLogger.Print("connect mysql server failed")
-			return
+	err = dao.Setup()
+	if err != nil {
+		dao.Close()
+		return nil, err
+	}
+
+	return dao, nil
+}
+
+func openJzDao(config *JzSourceConfig, driver string, source string) (*JzDao, error) {
+	db, err := sql.Open(driver, source)
+	if err != nil {
+		JzLogger.Printf("connect %s server failed", driver)
+		return nil, err
+	}
+
+	cursor := config.Cursor
+	if len(cursor) == 0 {
+		cursor = fmt.Sprintf("./%s.cursor", config.Name)
+	}
+
+	return &JzDao{
+		name:   config.Name,
+		driver: driver,
+		db:     db,
+		id:     0,
+		config: config,
+		table:  &config.Table,
+		owner:  RsyncConfig().Instance,
+		cursor: cursor,
+		closed: make(chan bool),
+		ahead:  make(map[int]bool),
+
+		inflight: make(map[int]bool),
+	}, nil
+}
+
+// 校验表结构 恢复轮询游标 崩溃前未完成的任务仅在启动时恢复
+func (dao *JzDao) Setup() error {
+	err := dao.CheckSchema()
+	if err != nil {
+		return err
+	}
+
+	dao.loadCursor()
+
+	if dao.config.Lease > 0 {
+		go func() {
+			interval := time.NewTicker(time.Second * time.Duration(dao.config.Lease) / 3)
+			defer interval.Stop()
+
+			for {
+				select {
+				case <-dao.closed:
+					return
+				case <-interval.C:
+					dao.renewLeases()
+				}
+			}
+		}()
+	}
+
+	return nil
+}
+
+func (dao *JzDao) loadCursor() {
+	data, err := ioutil.ReadFile(dao.cursor)
+	if err != nil {
+		return
+	}
+
+	id, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		JzLogger.Printf("load %s cursor %s failed %v", dao.name, dao.cursor, err)
+		return
+	}
+
+	dao.id = id
+	dao.head = id
+	dao.saved = id
+	JzLogger.Printf("load %s cursor %d", dao.name, id)
+}
+
+// 保存游标 调用方需持有cursorLock
+func (dao *JzDao) saveCursor() {
+	if dao.cursorPaused {
+		return
+	}
+
+	mark := dao.head
+	for id := range dao.inflight {
+		if id-1 < mark {
+			mark = id - 1
 		}
+	}
+
+	if mark == dao.saved {
+		return
+	}
+
+	err := WriteFileAtomic(dao.cursor, []byte(strconv.Itoa(mark)))
+	if err != nil {
+		JzLogger.Printf("save %s cursor %s failed %v", dao.name, dao.cursor, err)
+		return
+	}
+
+	dao.saved = mark
+}
+
+// 记录游标推进及新拉取的任务
+func (dao *JzDao) trackCursor(head int, ids []int) {
+	dao.cursorLock.Lock()
+	defer dao.cursorLock.Unlock()
+
+	dao.head = head
+	for _, id := range ids {
+		dao.inflight[id] = true
+	}
+
+	dao.saveCursor()
+}
+
+// 任务完成后游标才越过该任务
+func (dao *JzDao) releaseCursor(id int) {
+	dao.cursorLock.Lock()
+	defer dao.cursorLock.Unlock()
+
+	if !dao.inflight[id] {
+		return
+	}
+
+	delete(dao.inflight, id)
+	dao.saveCursor()
+}
+
+// 租约过期及本实例上次运行未完成的任务重置为失败 并回退游标以重新拉取
+func (dao *JzDao) recoverLeases() error {
+	if dao.config.Lease <= 0 {
+		return nil
+	}
+
+	columns := dao.table.Columns
+	now := time.Now().Unix()
+
+	var minId sql.NullInt64
+	err := dao.db.QueryRow(dao.rebind(fmt.Sprintf("select min(%s) from %s where %s=? AND (%s<? OR %s=?)", columns.Id, dao.table.Name, columns.Status, columns.Lease, columns.Owner)),
+		dao.statusValue(102), now, dao.owner).Scan(&minId)
+	if err != nil {
+		return err
+	}
+
+	if !minId.Valid {
+		return nil
+	}
+
+	result, err := dao.db.Exec(dao.rebind(fmt.Sprintf("update %s set %s=? where %s=? AND (%s<? OR %s=?)", dao.table.Name, columns.Status, columns.Status, columns.Lease, columns.Owner)),
+		dao.statusValue(500), dao.statusValue(102), now, dao.owner)
+	if err != nil {
+		return err
+	}
+
+	n, _ := result.RowsAffected()
+	JzLogger.Printf("recover %d stale tasks from %s min id %d", n, dao.name, minId.Int64)
+
+	if int(minId.Int64)-1 < dao.id {
+		dao.id = int(minId.Int64) - 1
+		dao.trackCursor(dao.id, nil)
+	}
+
+	return nil
+}
+
+// 启动时校验配置的表及字段在数据库中存在
+func (dao *JzDao) CheckSchema() error {
+	columns := dao.table.Columns
+	names := []string{columns.Id, columns.Uri, columns.Md5, columns.Dest, columns.Status, columns.At}
+	if dao.config.Retry.Max > 0 {
+		names = append(names, columns.Attempts)
+	}
+
+	if dao.config.Lease > 0 {
+		names = append(names, columns.Owner, columns.Lease)
+	}
 
-		jzDaoInstance = &JzDao{
-			db: db,
-			id: 0,
+	if len(columns.Priority) > 0 {
+		names = append(names, columns.Priority)
+	}
+
+	rows, err := dao.db.Query(fmt.Sprintf("select %s from %s where 1=0", strings.Join(names, ","), dao.table.Name))
+	if err != nil {
+		return errors.New(fmt.Sprintf("task source %s table %s with columns %s check failed: %v",
+			dao.name, dao.table.Name, strings.Join(names, ","), err))
+	}
+	rows.Close()
+
+	if len(dao.table.Targets) == 0 {
+		return nil
+	}
+
+	tc := dao.table.TargetColumns
+	names = []string{tc.FileId, tc.Target, tc.Status, tc.Attempts, tc.Error, tc.Finished}
+	rows, err = dao.db.Query(fmt.Sprintf("select %s from %s where 1=0", strings.Join(names, ","), dao.table.Targets))
+	if err != nil {
+		return errors.New(fmt.Sprintf("task source %s targets table %s with columns %s check failed: %v",
+			dao.name, dao.table.Targets, strings.Join(names, ","), err))
+	}
+	rows.Close()
+
+	return nil
+}
+
+// 将任务状态转换为表中配置的状态值
+func (dao *JzDao) statusValue(status int) int {
+	switch status {
+	case 0:
+		return dao.table.Status.Pending
+	case 200:
+		return dao.table.Status.Done
+	case 404:
+		return dao.table.Status.NotFound
+	case 500:
+		return dao.table.Status.Failed
+	case 206:
+		return dao.table.Status.Partial
+	case 410:
+		return dao.table.Status.Dead
+	case 102:
+		return dao.table.Status.InProgress
+	case 499:
+		return dao.table.Status.Cancelled
+	}
+
+	return status
+}
+
+// 将表中的状态值转换为任务状态
+func (dao *JzDao) statusState(value int) string {
+	switch value {
+	case dao.table.Status.Done:
+		return TASK_DONE
+	case dao.table.Status.NotFound:
+		return TASK_NOTFOUND
+	case dao.table.Status.Failed:
+		return TASK_FAILED
+	case dao.table.Status.Partial:
+		return TASK_PARTIAL
+	case dao.table.Status.Dead:
+		return TASK_DEAD
+	case dao.table.Status.InProgress:
+		return TASK_RUNNING
+	case dao.table.Status.Cancelled:
+		return TASK_CANCELLED
+	}
+
+	return TASK_PENDING
+}
+
+// postgres使用$n作为参数占位符
+func (dao *JzDao) rebind(query string) string {
+	if dao.driver != "postgres" {
+		return query
+	}
+
+	n := 0
+	var b strings.Builder
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString(fmt.Sprintf("$%d", n))
+			continue
 		}
+		b.WriteRune(c)
+	}
+
+	return b.String()
+}
+
+func (dao *JzDao) Name() string {
+	return dao.name
+}
+
+func (dao *JzDao) Dao() *JzDao {
+	return dao
+}
+
+func (dao *JzDao) Watch(rsync *JzRsync) {
+	if dao.config.Binlog.ServerId == 0 {
+		return
+	}
+
+	//启用选主时只在主实例上消费binlog
+	if rsync.leader == nil {
+		dao.startBinlog(rsync)
+		return
+	}
+
+	rsync.leader.OnElected(dao, func() {
+		dao.startBinlog(rsync)
+	})
+
+	rsync.leader.OnRevoked(dao, func() {
+		dao.stopBinlog()
 	})
 
-	return jzDaoInstance
+	//重新加载配置时新增的来源
+	if rsync.leader.IsLeader() {
+		dao.startBinlog(rsync)
+	}
+}
+
+func (dao *JzDao) startBinlog(rsync *JzRsync) {
+	dao.Lock()
+	if dao.retired || dao.binlog != nil {
+		dao.Unlock()
+		return
+	}
+	binlog := NewJzBinlog(dao, rsync)
+	dao.binlog = binlog
+	dao.Unlock()
+
+	binlog.Start()
+}
+
+// 被新的来源替换 停止binlog及游标保存 只处理已拉取任务的回写
+func (dao *JzDao) Retire() {
+	dao.Lock()
+	dao.retired = true
+	dao.Unlock()
+
+	dao.cursorLock.Lock()
+	dao.cursorPaused = true
+	dao.cursorLock.Unlock()
+
+	dao.stopBinlog()
+}
+
+func (dao *JzDao) stopBinlog() {
+	dao.Lock()
+	binlog := dao.binlog
+	dao.binlog = nil
+	dao.Unlock()
+
+	if binlog != nil {
+		binlog.Stop()
+	}
 }
 
 func (dao *JzDao) Close() {
+	close(dao.closed)
+
+	dao.Lock()
+	dao.retired = true
+	dao.Unlock()
+
+	dao.stopBinlog()
+
 	if dao.db != nil {
 		dao.db.Close()
 	}
 
-	JzLogger.Print("db closed")
+	JzLogger.Printf("db %s closed", dao.name)
 }
 
 func (dao *JzDao) CancelTask(id int, status int) {
@@ -64,100 +439,488 @@ func (dao *JzDao) CancelTask(id int, status int) {
 	}
 }
 
-func (dao *JzDao) GetTasks() ([]*JzTask, error) {
+func (dao *JzDao) GetTasks(limit int) ([]*JzTask, error) {
 	dao.Lock()
 	defer dao.Unlock()
 
-	t := time.Now().Unix()
-	rows, err := dao.db.Query(fmt.Sprintf(`
-			select id,uri,md5,dest 
-			from sync_files 
-			where id>? AND status!=404 AND status!=200 AND uri!= '' AND at <=%d AND md5!='' AND dest!='' 
-			order by id asc`, t), dao.id)
+	columns := dao.table.Columns
+	conditions := make([]string, 0)
+	args := make([]interface{}, 0)
+	result := make([]*JzTask, 0)
+
+	//binlog正常消费时无需按游标轮询 仅在追上积压任务前逐页拉取
+	paging := !dao.drained || dao.binlog == nil || !dao.binlog.Running()
+	if paging {
+		conditions = append(conditions, fmt.Sprintf("%s>?", columns.Id))
+		args = append(args, dao.id)
+
+		//游标之后的高优先级任务优先拉取 不推进游标
+		if len(columns.Priority) > 0 {
+			tasks, _, err := dao.queryTasks(fmt.Sprintf("%s>? AND %s>0", columns.Id, columns.Priority),
+				fmt.Sprintf("%s desc,%s asc", columns.Priority, columns.Id), limit, true, dao.id)
+			if err != nil {
+				return nil, err
+			}
+
+			result = append(result, tasks...)
+			limit -= len(tasks)
+			if limit <= 0 {
+				return result, nil
+			}
+		}
+	}
+
+	//游标已越过或binlog通知时尚未到期的定时任务
+	conditions = append(conditions, fmt.Sprintf("(%s=? AND %s>0)", columns.Status, columns.At))
+	args = append(args, dao.statusValue(0))
+
+	//重新拉取到期重试的任务
+	if dao.config.Retry.Max > 0 {
+		conditions = append(conditions, fmt.Sprintf("%s IN (?,?)", columns.Status))
+		args = append(args, dao.statusValue(500), dao.statusValue(206))
+	}
+
+	//接管租约过期的其他实例的任务
+	if dao.config.Lease > 0 {
+		conditions = append(conditions, fmt.Sprintf("(%s=? AND %s<?)", columns.Status, columns.Lease))
+		args = append(args, dao.statusValue(102), time.Now().Unix())
+	}
+
+	condition := fmt.Sprintf("(%s)", strings.Join(conditions, " OR "))
+
+	queryId := dao.id
+	tasks, rows, err := dao.queryTasks(condition, fmt.Sprintf("%s asc", columns.Id), limit, false, args...)
 	if err != nil {
-		JzLogger.Print("prepare sql failed", err)
 		return nil, err
 	}
-	defer rows.Close()
+	result = append(result, tasks...)
 
-	var queryId int
-	var id int
-	var imgUri sql.NullString
-	var md5Sum sql.NullString
-	var destName sql.NullString
+	//不足一页说明已追上积压任务
+	if paging {
+		dao.drained = rows < limit
+	}
 
-	queryId = dao.id
-	result := make([]*JzTask, 0)
+	JzLogger.Printf("pull %d tasks from %s by min id %d limit %d", len(result), dao.name, queryId, limit)
+
+	return result, nil
+}
+
+// 按id拉取指定任务 不受轮询游标限制
+func (dao *JzDao) GetTasksByIds(ids []int) ([]*JzTask, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	dao.Lock()
+	defer dao.Unlock()
+
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	//指定的任务不推进游标 避免跳过游标与其之间的任务
+	tasks, _, err := dao.queryTasks(fmt.Sprintf("%s IN (?%s)", dao.table.Columns.Id, strings.Repeat(",?", len(ids)-1)),
+		fmt.Sprintf("%s asc", dao.table.Columns.Id), 0, true, args...)
+
+	return tasks, err
+}
+
+// binlog通知的记录只拉取待同步的 避免回写状态引发重复同步
+func (dao *JzDao) GetPendingTasksByIds(ids []int) ([]*JzTask, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	dao.Lock()
+	defer dao.Unlock()
+
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	args = append(args, dao.statusValue(0))
+
+	//积压任务未拉取完时不推进游标
+	tasks, _, err := dao.queryTasks(fmt.Sprintf("%s IN (?%s) AND %s=?", dao.table.Columns.Id, strings.Repeat(",?", len(ids)-1), dao.table.Columns.Status),
+		fmt.Sprintf("%s asc", dao.table.Columns.Id), 0, !dao.drained, args...)
+
+	return tasks, err
+}
+
+// 指定记录中尚未到期的待同步任务最早的到期时间 没有时返回0
+func (dao *JzDao) NextScheduled(ids []int) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	args = append(args, dao.statusValue(0), time.Now().Unix())
+
+	columns := dao.table.Columns
+
+	var at sql.NullInt64
+	err := dao.db.QueryRow(dao.rebind(fmt.Sprintf("select min(%s) from %s where %s IN (?%s) AND %s=? AND %s>?",
+		columns.At, dao.table.Name, columns.Id, strings.Repeat(",?", len(ids)-1), columns.Status, columns.At)), args...).Scan(&at)
+	if err != nil {
+		return 0, err
+	}
+
+	return at.Int64, nil
+}
+
+// 按条件拉取任务 ahead为true时为提前拉取的任务 不推进游标 limit为0时不限制数量 同时返回查询到的记录数
+func (dao *JzDao) queryTasks(condition string, ordering string, limit int, ahead bool, args ...interface{}) ([]*JzTask, int, error) {
+	columns := dao.table.Columns
+
+	//未配置优先级字段时所有任务优先级为0
+	priority := "0"
+	if len(columns.Priority) > 0 {
+		priority = columns.Priority
+	}
+
+	limitClause := ""
+	if limit > 0 {
+		limitClause = fmt.Sprintf(" limit %d", limit)
+	}
+
+	args = append(args, dao.statusValue(404), dao.statusValue(200), dao.statusValue(410), dao.statusValue(499), time.Now().Unix())
+	rows, err := dao.db.Query(dao.rebind(fmt.Sprintf(`
+			select %s,%s,%s,%s,%s,%s 
+			from %s 
+			where %s AND %s!=? AND %s!=? AND %s!=? AND %s!=? AND %s!= '' AND %s<=? AND %s!='' AND %s!='' 
+			order by %s%s`,
+		columns.Id, columns.Uri, columns.Md5, columns.Dest, columns.Status, priority,
+		dao.table.Name,
+		condition, columns.Status, columns.Status, columns.Status, columns.Status, columns.Uri, columns.At, columns.Md5, columns.Dest,
+		ordering, limitClause)), args...)
+	if err != nil {
+		JzLogger.Print("prepare sql failed", err)
+		return nil, 0, err
+	}
 
+	//先读完所有记录再回写状态 sqlite只有一个连接 游标未关闭时回写会一直等待
+	records := make([]*jzTaskRecord, 0)
 	for rows.Next() {
-		err := rows.Scan(&id, &imgUri, &md5Sum, &destName)
+		r := &jzTaskRecord{}
+		err := rows.Scan(&r.id, &r.uri, &r.md5, &r.dest, &r.status, &r.priority)
 		if err != nil {
 			JzLogger.Print("pull task scan failed", err)
 			continue
 		}
+		records = append(records, r)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, 0, err
+	}
 
-		if false == imgUri.Valid || len(imgUri.String) == 0 {
-			dao.CancelTask(id, 404)
-			JzLogger.Printf("pull empty task with %d", id)
+	queryId := dao.id
+	result := make([]*JzTask, 0)
+	retries := make([]*JzTask, 0)
+
+	for _, r := range records {
+		id := r.id
+
+		if _, ok := GlobalData.TaskMap.Load(TaskKey(dao.name, id)); ok {
 			continue
 		}
 
-		if false == destName.Valid || len(destName.String) == 0 {
+		if ahead {
+			if dao.ahead[id] {
+				continue
+			}
+
+			//游标之后的任务记录下来 游标推进到时跳过
+			if id > dao.id {
+				dao.ahead[id] = true
+			}
+		} else {
+			if id > dao.id {
+				dao.id = id
+			}
+
+			//已提前拉取过的任务
+			if dao.ahead[id] {
+				delete(dao.ahead, id)
+				continue
+			}
+		}
+
+		task, err := AssembleSourceTask(dao, id, r.uri.String, r.md5.String, r.dest.String)
+		if err != nil {
 			dao.CancelTask(id, 404)
-			JzLogger.Printf("pull unknown target server task with %d", id)
 			continue
 		}
 
-		if _, ok := GlobalData.TaskMap.Load(id); ok {
-			continue
+		task.Priority = int(r.priority.Int64)
+
+		GlobalData.TaskMap.Store(task.Key(), true)
+
+		JzLogger.Print("got task from db", task)
+
+		result = append(result, task)
+
+		if r.status.Valid && (int(r.status.Int64) == dao.table.Status.Failed || int(r.status.Int64) == dao.table.Status.Partial) {
+			retries = append(retries, task)
 		}
+	}
 
-		if id > dao.id {
-			dao.id = id
+	//游标已越过但未被轮询到的提前拉取记录
+	if dao.id != queryId {
+		for id := range dao.ahead {
+			if id <= dao.id {
+				delete(dao.ahead, id)
+			}
 		}
+	}
 
-		task, err := AssembleTask(id, imgUri.String)
-		if err != nil {
-			dao.CancelTask(id, 404)
-			JzLogger.Printf("assemble task file %s failed %v", path.Join(jzRsyncConfig.Repertory, imgUri.String), err)
+	claimed := make([]*JzTask, 0, len(result))
+	fetched := make([]int, 0)
+	for _, task := range result {
+		if !dao.claim(task.Id) {
+			GlobalData.TaskMap.Delete(task.Key())
+			JzLogger.Printf("task %s claimed by other instance", task.Key())
 			continue
 		}
+		claimed = append(claimed, task)
 
-		if task.Size == 0 {
-			dao.CancelTask(id, 404)
-			JzLogger.Printf("get task file %s size failed", path.Join(jzRsyncConfig.Repertory, imgUri.String))
-			continue
+		if task.Id > queryId {
+			fetched = append(fetched, task.Id)
 		}
+	}
+	result = claimed
 
-		if md5Sum.Valid && len(md5Sum.String) > 0 && strings.ToLower(md5Sum.String) != task.M5Sum {
-			dao.CancelTask(id, 404)
-			JzLogger.Printf("get task file %s md5sum failed %s %s", path.Join(jzRsyncConfig.Repertory, imgUri.String), strings.ToLower(md5Sum.String), task.M5Sum)
+	if dao.id != queryId || len(fetched) > 0 {
+		dao.trackCursor(dao.id, fetched)
+	}
+
+	//重试的任务跳过已同步成功的目标
+	for _, task := range retries {
+		targets, err := dao.doneTargets(task.Id)
+		if err != nil {
+			JzLogger.Printf("get task %s done targets failed %v", task.Key(), err)
 			continue
 		}
+		task.DoneTargets = targets
+	}
 
-		task.HostNames = append(task.HostNames, strings.Split(strings.ToUpper(destName.String), ",")...)
+	return result, len(records), nil
+}
 
-		GlobalData.TaskMap.Store(id, true)
+// 标记任务为同步中 只认领待同步 失败及租约过期的任务
+func (dao *JzDao) claim(id int) bool {
+	if dao.config.Lease <= 0 {
+		return true
+	}
 
-		JzLogger.Print("got task from db", task)
+	columns := dao.table.Columns
+	now := time.Now().Unix()
+	result, err := dao.db.Exec(dao.rebind(fmt.Sprintf("update %s set %s=?,%s=?,%s=? where %s=? AND (%s IN (?,?,?) OR (%s=? AND %s<?))",
+		dao.table.Name, columns.Status, columns.Owner, columns.Lease, columns.Id, columns.Status, columns.Status, columns.Lease)),
+		dao.statusValue(102), dao.owner, now+int64(dao.config.Lease), id,
+		dao.statusValue(0), dao.statusValue(500), dao.statusValue(206), dao.statusValue(102), now)
+	if err != nil {
+		JzLogger.Printf("claim task %s failed %v", TaskKey(dao.name, id), err)
+		return false
+	}
 
-		result = append(result, task)
+	n, _ := result.RowsAffected()
+
+	return n > 0
+}
+
+// 续约本实例同步中的任务
+func (dao *JzDao) renewLeases() {
+	columns := dao.table.Columns
+	result, err := dao.db.Exec(dao.rebind(fmt.Sprintf("update %s set %s=? where %s=? AND %s=?", dao.table.Name, columns.Lease, columns.Status, columns.Owner)),
+		time.Now().Unix()+int64(dao.config.Lease), dao.statusValue(102), dao.owner)
+	if err != nil {
+		JzLogger.Printf("renew %s leases failed %v", dao.name, err)
+		return
+	}
+
+	if n, _ := result.RowsAffected(); n > 0 {
+		JzLogger.Printf("renew %d leases of %s", n, dao.name)
 	}
+}
 
-	JzLogger.Printf("pull %d tasks by min id %d", len(result), queryId)
+func (dao *JzDao) doneTargets(id int) ([]string, error) {
+	if len(dao.table.Targets) == 0 {
+		return nil, nil
+	}
+
+	tc := dao.table.TargetColumns
+	rows, err := dao.db.Query(dao.rebind(fmt.Sprintf("select %s from %s where %s=? AND %s=?",
+		tc.Target, dao.table.Targets, tc.FileId, tc.Status)), id, dao.statusValue(200))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]string, 0)
+	for rows.Next() {
+		var target string
+		if err := rows.Scan(&target); err == nil {
+			result = append(result, target)
+		}
+	}
 
 	return result, nil
 }
 
+func (dao *JzDao) ReportTarget(id int, result *JzTargetResult) error {
+	if len(dao.table.Targets) == 0 {
+		return nil
+	}
+
+	tc := dao.table.TargetColumns
+	r, err := dao.db.Exec(dao.rebind(fmt.Sprintf("update %s set %s=?,%s=%s+?,%s=?,%s=? where %s=? AND %s=?",
+		dao.table.Targets, tc.Status, tc.Attempts, tc.Attempts, tc.Error, tc.Finished, tc.FileId, tc.Target)),
+		dao.statusValue(result.Status), result.Attempts, result.Error, result.Finished, id, result.Target)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := r.RowsAffected(); n > 0 {
+		return nil
+	}
+
+	_, err = dao.db.Exec(dao.rebind(fmt.Sprintf("insert into %s (%s,%s,%s,%s,%s,%s) values (?,?,?,?,?,?)",
+		dao.table.Targets, tc.FileId, tc.Target, tc.Status, tc.Attempts, tc.Error, tc.Finished)),
+		id, result.Target, dao.statusValue(result.Status), result.Attempts, result.Error, result.Finished)
+
+	return err
+}
+
+// 查询表中记录的任务状态及各目标同步结果
+func (dao *JzDao) InspectTask(id int) (*JzTaskStatus, error) {
+	columns := dao.table.Columns
+
+	priority := "0"
+	if len(columns.Priority) > 0 {
+		priority = columns.Priority
+	}
+
+	var uri sql.NullString
+	var md5Sum sql.NullString
+	var dest sql.NullString
+	var status sql.NullInt64
+	var taskPriority sql.NullInt64
+
+	err := dao.db.QueryRow(dao.rebind(fmt.Sprintf("select %s,%s,%s,%s,%s from %s where %s=?",
+		columns.Uri, columns.Md5, columns.Dest, columns.Status, priority, dao.table.Name, columns.Id)), id).
+		Scan(&uri, &md5Sum, &dest, &status, &taskPriority)
+	if err == sql.ErrNoRows {
+		return nil, NOT_FOUND_TASKS
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := &JzTaskStatus{
+		Id:       TaskKey(dao.name, id),
+		File:     uri.String,
+		Md5:      md5Sum.String,
+		Targets:  strings.Split(strings.ToUpper(dest.String), ","),
+		Priority: int(taskPriority.Int64),
+		State:    dao.statusState(int(status.Int64)),
+		Results:  make([]*JzTargetResult, 0),
+	}
+
+	if len(dao.table.Targets) == 0 {
+		return result, nil
+	}
+
+	tc := dao.table.TargetColumns
+	rows, err := dao.db.Query(dao.rebind(fmt.Sprintf("select %s,%s,%s,%s,%s from %s where %s=? order by %s",
+		tc.Target, tc.Status, tc.Attempts, tc.Error, tc.Finished, dao.table.Targets, tc.FileId, tc.Target)), id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var target string
+		var targetStatus int
+		var attempts int
+		var targetError sql.NullString
+		var finished sql.NullInt64
+		if err := rows.Scan(&target, &targetStatus, &attempts, &targetError, &finished); err != nil {
+			return nil, err
+		}
+
+		code := 500
+		if targetStatus == dao.table.Status.Done {
+			code = 200
+		}
+
+		result.Results = append(result.Results, &JzTargetResult{
+			Target:   target,
+			Status:   code,
+			Attempts: attempts,
+			Error:    targetError.String,
+			Finished: finished.Int64,
+		})
+
+		if finished.Int64 > result.Finished {
+			result.Finished = finished.Int64
+		}
+	}
+
+	return result, rows.Err()
+}
+
 func (dao *JzDao) UpdateTask(id int, status int) (int64, error) {
-	stmt, err := dao.db.Prepare("update sync_files set status=? where id=?")
+	defer dao.releaseCursor(id)
+
+	if dao.config.Retry.Max > 0 && (status == 500 || status == 206) {
+		return dao.rescheduleTask(id, status)
+	}
+
+	stmt, err := dao.db.Prepare(dao.rebind(fmt.Sprintf("update %s set %s=? where %s=?", dao.table.Name, dao.table.Columns.Status, dao.table.Columns.Id)))
 	if err != nil {
 		JzLogger.Print("prepare sql failed", err)
 		return 0, err
 	}
 	defer stmt.Close()
 
-	result, err := stmt.Exec(status, id)
+	result, err := stmt.Exec(dao.statusValue(status), id)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// 同步失败的任务按指数退避推迟at 超过最大次数后标记为dead
+func (dao *JzDao) rescheduleTask(id int, status int) (int64, error) {
+	columns := dao.table.Columns
+
+	var attempts int
+	err := dao.db.QueryRow(dao.rebind(fmt.Sprintf("select %s from %s where %s=?", columns.Attempts, dao.table.Name, columns.Id)), id).Scan(&attempts)
+	if err != nil {
+		return 0, err
+	}
+
+	attempts++
+	at := time.Now().Unix()
+
+	if attempts >= dao.config.Retry.Max {
+		status = 410
+		JzLogger.Printf("task %s failed %d times mark dead", TaskKey(dao.name, id), attempts)
+	} else {
+		delay := RetryDelay(attempts, dao.config.Retry.Base, dao.config.Retry.MaxDelay)
+		at += int64(delay)
+		JzLogger.Printf("task %s failed %d times retry after %ds", TaskKey(dao.name, id), attempts, delay)
+	}
+
+	result, err := dao.db.Exec(dao.rebind(fmt.Sprintf("update %s set %s=?,%s=?,%s=? where %s=?", dao.table.Name, columns.Status, columns.Attempts, columns.At, columns.Id)),
+		dao.statusValue(status), attempts, at, id)
 	if err != nil {
 		return 0, err
 	}
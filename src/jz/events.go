@@ -0,0 +1,120 @@
+package jz
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// 事件频道
+const (
+	EVENT_TASK_DONE   = "task:done"
+	EVENT_TASK_FAILED = "task:failed"
+	EVENT_TARGET_DOWN = "target:down"
+	EVENT_TARGET_UP   = "target:up"
+)
+
+type JzEvent struct {
+	Event   string        `json:"event"`
+	Time    int64         `json:"time"`
+	Task    *JzTaskStatus `json:"task,omitempty"`
+	Target  string        `json:"target,omitempty"`
+	Address string        `json:"address,omitempty"`
+}
+
+// 向订阅者推送消息 由redis服务实现
+type JzPublisher interface {
+	Publish(channel string, value []byte) (int, error)
+}
+
+// 连接及目标的状态 启动后未确认前为unknown
+const (
+	CONNECTION_DOWN    int32 = -1
+	CONNECTION_UNKNOWN int32 = 0
+	CONNECTION_UP      int32 = 1
+)
+
+// 每个目标的可用连接数及状态 全部断开时发布target:down 从down恢复时发布target:up
+// conns为已报告状态的连接数 全部释放时删除该目标的记录 移除后重新添加的同名目标重新计算
+var jzTargetStates = struct {
+	sync.Mutex
+	conns     map[string]int
+	connected map[string]int
+	state     map[string]int32
+}{conns: make(map[string]int), connected: make(map[string]int), state: make(map[string]int32)}
+
+func PublishEvent(e *JzEvent) {
+	if GlobalData.Publisher == nil {
+		return
+	}
+
+	e.Time = time.Now().Unix()
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+
+	if _, err := GlobalData.Publisher.Publish(e.Event, data); err != nil {
+		JzLogger.Printf("publish %s event failed %v", e.Event, err)
+	}
+}
+
+// 任务结束后按状态发布事件 部分目标失败视为失败
+func PublishTaskEvent(t *JzTask) {
+	status := t.Status()
+
+	switch status.State {
+	case TASK_DONE:
+		PublishEvent(&JzEvent{Event: EVENT_TASK_DONE, Task: status})
+	case TASK_FAILED, TASK_PARTIAL:
+		PublishEvent(&JzEvent{Event: EVENT_TASK_FAILED, Task: status})
+	}
+}
+
+// 目标的单个连接状态由old变为state 变为unknown时只释放连接不改变目标状态
+func TargetConnectionChanged(target *JzTargetServer, old int32, state int32) {
+	jzTargetStates.Lock()
+	if old == CONNECTION_UNKNOWN {
+		jzTargetStates.conns[target.Name]++
+	} else if state == CONNECTION_UNKNOWN {
+		jzTargetStates.conns[target.Name]--
+	}
+
+	if jzTargetStates.conns[target.Name] <= 0 {
+		delete(jzTargetStates.conns, target.Name)
+		delete(jzTargetStates.connected, target.Name)
+		delete(jzTargetStates.state, target.Name)
+		jzTargetStates.Unlock()
+		return
+	}
+
+	n := jzTargetStates.connected[target.Name]
+	if state == CONNECTION_UP {
+		n++
+	} else if old == CONNECTION_UP && n > 0 {
+		n--
+	}
+	jzTargetStates.connected[target.Name] = n
+
+	prev := jzTargetStates.state[target.Name]
+	next := prev
+	if n > 0 {
+		next = CONNECTION_UP
+	} else if state == CONNECTION_DOWN {
+		next = CONNECTION_DOWN
+	}
+	jzTargetStates.state[target.Name] = next
+	jzTargetStates.Unlock()
+
+	if next == prev {
+		return
+	}
+
+	if next == CONNECTION_DOWN {
+		JzLogger.Printf("target server %s[%s] is down", target.Name, target.Address)
+		PublishEvent(&JzEvent{Event: EVENT_TARGET_DOWN, Target: target.Name, Address: target.Address})
+	} else if prev == CONNECTION_DOWN {
+		JzLogger.Printf("target server %s[%s] is up", target.Name, target.Address)
+		PublishEvent(&JzEvent{Event: EVENT_TARGET_UP, Target: target.Name, Address: target.Address})
+	}
+}
@@ -0,0 +1,93 @@
+package jz
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+type testPublisher struct {
+	sync.Mutex
+	events []string
+}
+
+func (obj *testPublisher) Publish(channel string, value []byte) (int, error) {
+	obj.Lock()
+	defer obj.Unlock()
+
+	obj.events = append(obj.events, channel)
+	return 1, nil
+}
+
+func (obj *testPublisher) take() []string {
+	obj.Lock()
+	defer obj.Unlock()
+
+	events := obj.events
+	obj.events = nil
+	return events
+}
+
+func setupTestPublisher(t *testing.T) *testPublisher {
+	publisher := &testPublisher{}
+	old := GlobalData.Publisher
+	GlobalData.Publisher = publisher
+	t.Cleanup(func() {
+		GlobalData.Publisher = old
+	})
+
+	return publisher
+}
+
+func TestTargetConnectionChanged(t *testing.T) {
+	publisher := setupTestPublisher(t)
+
+	type change struct {
+		conn  int
+		state int32
+	}
+
+	cases := []struct {
+		name    string
+		changes []change
+		expect  []string
+	}{
+		{"unreachable from start", []change{{0, CONNECTION_DOWN}, {1, CONNECTION_DOWN}}, []string{EVENT_TARGET_DOWN}},
+		{"up from start", []change{{0, CONNECTION_UP}, {1, CONNECTION_UP}}, []string{}},
+		{"one connection down", []change{{0, CONNECTION_UP}, {1, CONNECTION_UP}, {0, CONNECTION_DOWN}}, []string{}},
+		{"all down then up", []change{{0, CONNECTION_UP}, {1, CONNECTION_UP}, {0, CONNECTION_DOWN}, {1, CONNECTION_DOWN}, {1, CONNECTION_UP}, {0, CONNECTION_UP}},
+			[]string{EVENT_TARGET_DOWN, EVENT_TARGET_UP}},
+		{"down from start then up", []change{{0, CONNECTION_DOWN}, {0, CONNECTION_UP}}, []string{EVENT_TARGET_DOWN, EVENT_TARGET_UP}},
+		{"released connections", []change{{0, CONNECTION_UP}, {0, CONNECTION_UNKNOWN}, {1, CONNECTION_UP}}, []string{}},
+		{"removed and added again", []change{{0, CONNECTION_DOWN}, {0, CONNECTION_UNKNOWN}, {0, CONNECTION_DOWN}}, []string{EVENT_TARGET_DOWN, EVENT_TARGET_DOWN}},
+	}
+
+	for i, c := range cases {
+		target := &JzTargetServer{Name: fmt.Sprintf("T%d", i)}
+		conns := []int32{CONNECTION_UNKNOWN, CONNECTION_UNKNOWN}
+		for _, ch := range c.changes {
+			if conns[ch.conn] != ch.state {
+				TargetConnectionChanged(target, conns[ch.conn], ch.state)
+				conns[ch.conn] = ch.state
+			}
+		}
+
+		if events := publisher.take(); fmt.Sprint(events) != fmt.Sprint(c.expect) {
+			t.Errorf("%s: events %v, expect %v", c.name, events, c.expect)
+		}
+
+		//全部释放后不保留该目标的记录
+		for i := range conns {
+			if conns[i] != CONNECTION_UNKNOWN {
+				TargetConnectionChanged(target, conns[i], CONNECTION_UNKNOWN)
+			}
+		}
+
+		jzTargetStates.Lock()
+		_, ok := jzTargetStates.state[target.Name]
+		jzTargetStates.Unlock()
+		if ok || len(publisher.take()) > 0 {
+			t.Errorf("%s: expect released silently", c.name)
+		}
+	}
+}
@@ -4,8 +4,12 @@ import "sync"
 
 type TGlobalData struct {
 	TaskMap *sync.Map
+	Auditor JzAuditor
+	Tasks *JzTaskTracker
+	Publisher JzPublisher
 }
 
 var GlobalData = &TGlobalData{
 	TaskMap:new(sync.Map),
+	Tasks:NewJzTaskTracker(10000),
 }
@@ -0,0 +1,68 @@
+package jz
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 按redis INFO格式输出运行状态
+func (obj *JzRsync) Info() string {
+	var buf bytes.Buffer
+
+	buf.WriteString("# Server\r\n")
+	fmt.Fprintf(&buf, "version:%s\r\n", VERSION)
+	fmt.Fprintf(&buf, "instance:%s\r\n", RsyncConfig().Instance)
+	fmt.Fprintf(&buf, "uptime_in_seconds:%d\r\n", int64(time.Since(obj.started)/time.Second))
+
+	pool := obj.Pool()
+
+	inflight := 0
+	GlobalData.TaskMap.Range(func(key, value interface{}) bool {
+		inflight++
+		return true
+	})
+
+	buf.WriteString("\r\n# Tasks\r\n")
+	fmt.Fprintf(&buf, "queue_length:%d\r\n", obj.queue.Len())
+	fmt.Fprintf(&buf, "paused_tasks:%d\r\n", obj.Held())
+	fmt.Fprintf(&buf, "inflight_tasks:%d\r\n", inflight)
+	fmt.Fprintf(&buf, "scheduled_tasks:%d\r\n", obj.scheduler.Len())
+	fmt.Fprintf(&buf, "transfer_slots:%d\r\n", cap(pool.channel))
+	fmt.Fprintf(&buf, "transfer_slots_free:%d\r\n", len(pool.channel))
+	fmt.Fprintf(&buf, "last_poll_time:%d\r\n", atomic.LoadInt64(&obj.lastPoll))
+
+	buf.WriteString("\r\n# Stats\r\n")
+	fmt.Fprintf(&buf, "total_files_sent:%d\r\n", atomic.LoadInt64(&obj.files))
+	fmt.Fprintf(&buf, "total_bytes_sent:%d\r\n", atomic.LoadInt64(&obj.bytes))
+	fmt.Fprintf(&buf, "total_failures:%d\r\n", atomic.LoadInt64(&obj.failures))
+
+	//每个目标有多个传输连接 汇总连接状态及平均心跳耗时
+	buf.WriteString("\r\n# Targets\r\n")
+	for i, target := range pool.Targets() {
+		total, connected := 0, 0
+		var latency time.Duration
+		for _, ts := range pool.conns {
+			if ts.Target != target {
+				continue
+			}
+
+			total++
+			if ts.Connected() {
+				connected++
+				latency += ts.Latency()
+			}
+		}
+
+		if connected > 0 {
+			latency /= time.Duration(connected)
+		}
+
+		fmt.Fprintf(&buf, "target%d:name=%s,address=%s,groups=%s,paused=%t,connected=%d/%d,latency_ms=%.2f\r\n",
+			i, target.Name, target.Address, strings.Join(target.Group, "|"), obj.Paused(target.Name), connected, total, float64(latency)/float64(time.Millisecond))
+	}
+
+	return buf.String()
+}
@@ -0,0 +1,184 @@
+package jz
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// 基于任务来源数据库中的租约记录选主 只在主实例上执行的任务通过回调启停
+type JzLeader struct {
+	sync.Mutex
+	dao       *JzDao
+	table     string
+	name      string
+	lease     int
+	leader    bool
+	renewed   int64
+	onElected []*jzLeaderCallback
+	onRevoked []*jzLeaderCallback
+	stopped   chan bool
+	exited    chan bool
+}
+
+func NewJzLeader(dao *JzDao, config *JzLeaderConfig) *JzLeader {
+	return &JzLeader{
+		dao:     dao,
+		table:   config.Table,
+		name:    config.Name,
+		lease:   config.Lease,
+		stopped: make(chan bool),
+		exited:  make(chan bool),
+	}
+}
+
+// owner为注册回调的对象 被替换时按owner移除
+type jzLeaderCallback struct {
+	owner interface{}
+	f     func()
+}
+
+func (obj *JzLeader) OnElected(owner interface{}, f func()) {
+	obj.Lock()
+	defer obj.Unlock()
+
+	obj.onElected = append(obj.onElected, &jzLeaderCallback{owner, f})
+}
+
+func (obj *JzLeader) OnRevoked(owner interface{}, f func()) {
+	obj.Lock()
+	defer obj.Unlock()
+
+	obj.onRevoked = append(obj.onRevoked, &jzLeaderCallback{owner, f})
+}
+
+// 移除owner注册的回调
+func (obj *JzLeader) Unregister(owner interface{}) {
+	obj.Lock()
+	defer obj.Unlock()
+
+	remove := func(callbacks []*jzLeaderCallback) []*jzLeaderCallback {
+		result := make([]*jzLeaderCallback, 0, len(callbacks))
+		for _, c := range callbacks {
+			if c.owner != owner {
+				result = append(result, c)
+			}
+		}
+		return result
+	}
+
+	obj.onElected = remove(obj.onElected)
+	obj.onRevoked = remove(obj.onRevoked)
+}
+
+func (obj *JzLeader) IsLeader() bool {
+	obj.Lock()
+	defer obj.Unlock()
+
+	return obj.leader
+}
+
+// 当前主实例及其租约过期时间
+func (obj *JzLeader) Current() (string, int64, error) {
+	var owner string
+	var lease int64
+
+	err := obj.dao.db.QueryRow(obj.dao.rebind(fmt.Sprintf("select owner,lease from %s where name=?", obj.table)), obj.name).Scan(&owner, &lease)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if lease < time.Now().Unix() {
+		return "", lease, errors.New("no leader")
+	}
+
+	return owner, lease, nil
+}
+
+func (obj *JzLeader) Start() {
+	//记录不存在时插入 已存在时忽略插入失败
+	obj.dao.db.Exec(obj.dao.rebind(fmt.Sprintf("insert into %s (name,owner,lease) values (?,'',0)", obj.table)), obj.name)
+
+	go func() {
+		interval := time.NewTicker(time.Second * time.Duration(obj.lease) / 3)
+		defer interval.Stop()
+
+		obj.campaign()
+
+	L:
+		for {
+			select {
+			case <-obj.stopped:
+				break L
+			case <-interval.C:
+				obj.campaign()
+			}
+		}
+
+		obj.setLeader(false)
+		obj.dao.db.Exec(obj.dao.rebind(fmt.Sprintf("update %s set lease=0 where name=? AND owner=?", obj.table)), obj.name, RsyncConfig().Instance)
+
+		close(obj.exited)
+	}()
+}
+
+func (obj *JzLeader) Stop() {
+	close(obj.stopped)
+	<-obj.exited
+	JzLogger.Print("leader election stopped")
+}
+
+// 租约过期或本实例持有时更新租约 成功即为主实例
+func (obj *JzLeader) campaign() {
+	now := time.Now().Unix()
+	result, err := obj.dao.db.Exec(obj.dao.rebind(fmt.Sprintf("update %s set owner=?,lease=? where name=? AND (owner=? OR lease<?)", obj.table)),
+		RsyncConfig().Instance, now+int64(obj.lease), obj.name, RsyncConfig().Instance, now)
+	if err != nil {
+		JzLogger.Printf("leader %s campaign failed %v", obj.name, err)
+
+		//无法续约时在租约过期前主动放弃
+		obj.Lock()
+		expired := obj.leader && now-obj.renewed >= int64(obj.lease)*2/3
+		obj.Unlock()
+
+		if expired {
+			obj.setLeader(false)
+		}
+		return
+	}
+
+	n, _ := result.RowsAffected()
+	if n > 0 {
+		obj.Lock()
+		obj.renewed = now
+		obj.Unlock()
+	}
+
+	obj.setLeader(n > 0)
+}
+
+func (obj *JzLeader) setLeader(leader bool) {
+	obj.Lock()
+	if obj.leader == leader {
+		obj.Unlock()
+		return
+	}
+
+	obj.leader = leader
+	callbacks := obj.onRevoked
+	if leader {
+		callbacks = obj.onElected
+	}
+	obj.Unlock()
+
+	if leader {
+		JzLogger.Printf("instance %s elected as leader %s", RsyncConfig().Instance, obj.name)
+	} else {
+		JzLogger.Printf("instance %s revoked from leader %s", RsyncConfig().Instance, obj.name)
+	}
+
+	for _, c := range callbacks {
+		c.f()
+	}
+}
@@ -0,0 +1,33 @@
+package jz
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestJzLeaderUnregister(t *testing.T) {
+	setupTestConfig(t)
+
+	leader := NewJzLeader(nil, &JzLeaderConfig{Name: "test"})
+	events := make([]string, 0)
+
+	for _, owner := range []string{"a", "b"} {
+		owner := owner
+		leader.OnElected(owner, func() {
+			events = append(events, owner+":elected")
+		})
+		leader.OnRevoked(owner, func() {
+			events = append(events, owner+":revoked")
+		})
+	}
+
+	leader.setLeader(true)
+	leader.Unregister("a")
+	leader.setLeader(false)
+	leader.setLeader(true)
+
+	expect := []string{"a:elected", "b:elected", "b:revoked", "b:elected"}
+	if fmt.Sprint(events) != fmt.Sprint(expect) {
+		t.Fatalf("events %v, expect %v", events, expect)
+	}
+}
@@ -0,0 +1,134 @@
+package jz
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// 启用认证或socket时由redis服务在此监听上处理连接
+// 每个连接在redis服务读取命令前校验AUTH及命令权限 通过的命令原样交给redis服务执行
+type JzAuthListener struct {
+	net.Listener
+}
+
+func NewJzAuthListener(network string, address string) (*JzAuthListener, error) {
+	if network == "unix" {
+		os.Remove(address)
+	}
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	JzLogger.Printf("listen at %s %s", network, address)
+
+	return &JzAuthListener{Listener: listener}, nil
+}
+
+func (obj *JzAuthListener) Accept() (net.Conn, error) {
+	conn, err := obj.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &jzAuthConn{Conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// 认证状态随连接保存 认证配置每次使用时读取 重新加载配置后对新的认证生效
+type jzAuthConn struct {
+	net.Conn
+	writeLock sync.Mutex
+	reader    *bufio.Reader
+	user      *JzAuthUser
+	pending   []byte
+}
+
+// 订阅推送与回复可能同时写出
+func (c *jzAuthConn) Write(p []byte) (int, error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	return c.Conn.Write(p)
+}
+
+// redis服务写完上一条命令的回复后才读取下一条 被拒绝的命令在此直接回复 不会打乱回复顺序
+func (c *jzAuthConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		maxArgs, maxBulk := RESP_MAX_ARGS, RESP_MAX_BULK
+		if c.authUser() == nil {
+			maxArgs, maxBulk = RESP_MAX_UNAUTH_ARGS, RESP_MAX_UNAUTH_BULK
+		}
+
+		args, err := ReadRespCommand(c.reader, maxArgs, maxBulk)
+		if err != nil {
+			if _, ok := err.(RespProtocolError); ok {
+				JzLogger.Printf("client %s %v", c.RemoteAddr(), err)
+				c.Write([]byte("-ERR " + err.Error() + "\r\n"))
+			}
+			return 0, err
+		}
+
+		if len(args) == 0 {
+			continue
+		}
+
+		if reply, ok := c.check(args); !ok {
+			if _, err := c.Write([]byte(reply)); err != nil {
+				return 0, err
+			}
+
+			if strings.ToLower(args[0]) == "quit" {
+				return 0, io.EOF
+			}
+			continue
+		}
+
+		c.pending = EncodeRespCommand(args...)
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+
+	return n, nil
+}
+
+// 未启用认证时为拥有全部权限的默认用户
+func (c *jzAuthConn) authUser() *JzAuthUser {
+	if c.user == nil && !RsyncConfig().Auth.Enabled() {
+		return &JzAuthUser{Name: "default", Admin: true}
+	}
+
+	return c.user
+}
+
+// 返回false时命令不交给redis服务 直接回复reply
+func (c *jzAuthConn) check(args []string) (string, bool) {
+	switch strings.ToLower(args[0]) {
+	case "auth":
+		if u := RsyncConfig().Auth.Authenticate(args[1:]); u != nil {
+			c.user = u
+			return "+OK\r\n", false
+		}
+
+		JzLogger.Printf("client %s auth failed", c.RemoteAddr())
+		return "-WRONGPASS invalid username-password pair\r\n", false
+	case "quit":
+		return "+OK\r\n", false
+	}
+
+	user := c.authUser()
+	if user == nil {
+		return "-NOAUTH Authentication required.\r\n", false
+	}
+
+	if err := user.Allow(args); err != nil {
+		return "-NOPERM " + err.Error() + "\r\n", false
+	}
+
+	return "", true
+}
@@ -0,0 +1,138 @@
+package jz
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 模拟redis服务 回复收到的命令
+func newTestAuthListener(t *testing.T) string {
+	listener, err := NewJzAuthListener("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		listener.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(conn net.Conn) {
+				defer conn.Close()
+
+				reader := bufio.NewReader(conn)
+				for {
+					args, err := ReadRespCommand(reader, RESP_MAX_ARGS, RESP_MAX_BULK)
+					if err != nil {
+						return
+					}
+					conn.Write([]byte("+" + strings.Join(args, " ") + "\r\n"))
+				}
+			}(conn)
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestJzAuthListener(t *testing.T) {
+	setupTestConfig(t)
+	RsyncConfig().Auth = JzAuthConfig{
+		Password: "secret",
+		Users:    []JzAuthUser{{Name: "deploy", Password: "pw", Groups: "S1"}},
+	}
+	address := newTestAuthListener(t)
+
+	conn, err := DialResp(address, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	steps := []struct {
+		args   []string
+		expect string
+		err    string
+	}{
+		{[]string{"get", "1"}, "", "NOAUTH"},
+		{[]string{"auth", "deploy", "wrong"}, "", "WRONGPASS"},
+		{[]string{"subscribe", "task:done"}, "", "NOAUTH"},
+		{[]string{"auth", "deploy", "pw"}, "OK", ""},
+		{[]string{"get", "1"}, "get 1", ""},
+		{[]string{"flush"}, "", "NOPERM"},
+		{[]string{"set", "S2", "a.jpg"}, "", "NOPERM"},
+		{[]string{"set", "S1", "a b.jpg"}, "set S1 a b.jpg", ""},
+		{[]string{"auth", "secret"}, "OK", ""},
+		{[]string{"flush"}, "flush", ""},
+	}
+
+	for _, s := range steps {
+		v, err := conn.Do(s.args...)
+		if len(s.err) > 0 {
+			if err == nil || !strings.HasPrefix(err.Error(), s.err) {
+				t.Errorf("%v error %v, expect %s", s.args, err, s.err)
+			}
+			continue
+		}
+
+		if err != nil || v != s.expect {
+			t.Errorf("%v = %v %v, expect %s", s.args, v, err, s.expect)
+		}
+	}
+
+	if v, err := conn.Do("quit"); err != nil || v != "OK" {
+		t.Fatalf("quit = %v %v", v, err)
+	}
+
+	if _, err := conn.Do("get", "1"); err == nil {
+		t.Fatal("expect closed after quit")
+	}
+}
+
+// 未认证时超出上限的长度直接回复协议错误并断开 不按声明的长度分配内存
+func TestJzAuthListenerOversized(t *testing.T) {
+	setupTestConfig(t)
+	RsyncConfig().Auth = JzAuthConfig{Password: "secret"}
+	address := newTestAuthListener(t)
+
+	cases := []string{
+		"*9999999999999\r\n",
+		"*2\r\n$99999999999\r\n",
+		"*17\r\n",
+		"*1\r\n$-5\r\n",
+		"*1\r\n:1\r\n",
+		strings.Repeat("a", RESP_MAX_INLINE+1),
+	}
+
+	for _, c := range cases {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		conn.SetDeadline(time.Now().Add(time.Second * 5))
+		if _, err := conn.Write([]byte(c)); err != nil {
+			t.Fatal(err)
+		}
+
+		reader := bufio.NewReader(conn)
+		_, err = ReadRespValue(reader)
+		if err == nil || !strings.HasPrefix(err.Error(), "ERR Protocol error") {
+			t.Errorf("%.20q reply %v, expect protocol error", c, err)
+		}
+
+		if _, err := ReadRespValue(reader); err == nil {
+			t.Errorf("%.20q expect closed", c)
+		}
+
+		conn.Close()
+	}
+}
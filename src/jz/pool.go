@@ -0,0 +1,140 @@
+package jz
+
+import (
+	"fmt"
+	"sync"
+)
+
+// 传输通道池 每个通道包含到每个目标的一个连接 目标变更时整体替换为新的池
+// 被替换的池不再分配通道 已借出的通道全部归还后关闭连接
+type JzTransferPool struct {
+	targets    []*JzTargetServer
+	channel    chan []*JzRsyncTarget
+	conns      []*JzRsyncTarget
+	retired    chan bool
+	closed     chan bool
+	retireOnce sync.Once
+	closeOnce  sync.Once
+}
+
+func NewJzTransferPool(servers []JzTargetServer) *JzTransferPool {
+	targets := make([]*JzTargetServer, len(servers))
+	for i := range servers {
+		server := servers[i]
+		targets[i] = &server
+	}
+
+	transferChannelNumber := len(targets) * 10
+
+	obj := &JzTransferPool{
+		targets: targets,
+		channel: make(chan []*JzRsyncTarget, transferChannelNumber),
+		retired: make(chan bool),
+		closed:  make(chan bool),
+	}
+
+	for n := 0; n < transferChannelNumber; n++ {
+		target := make([]*JzRsyncTarget, len(targets))
+		for i := range targets {
+			target[i] = &JzRsyncTarget{Target: targets[i]}
+			target[i].Start()
+			target[i].Name = fmt.Sprintf("%d-%d", n, i)
+			obj.conns = append(obj.conns, target[i])
+		}
+		obj.channel <- target
+	}
+
+	return obj
+}
+
+func (obj *JzTransferPool) Targets() []*JzTargetServer {
+	return obj.targets
+}
+
+func (obj *JzTransferPool) Target(name string) *JzTargetServer {
+	for _, target := range obj.targets {
+		if target.Name == name {
+			return target
+		}
+	}
+
+	return nil
+}
+
+// 当前配置的目标副本 用于修改后重建
+func (obj *JzTransferPool) Servers() []JzTargetServer {
+	result := make([]JzTargetServer, len(obj.targets))
+	for i, target := range obj.targets {
+		result[i] = *target
+		result[i].Group = append(TagetGroups(nil), target.Group...)
+	}
+
+	return result
+}
+
+func (obj *JzTransferPool) HostNames() []string {
+	result := make([]string, 0)
+	for _, target := range obj.targets {
+		result = append(result, target.Group...)
+	}
+
+	return result
+}
+
+func (obj *JzTransferPool) Release(target []*JzRsyncTarget) {
+	obj.channel <- target
+
+	select {
+	case <-obj.retired:
+		obj.tryClose()
+	default:
+	}
+}
+
+func (obj *JzTransferPool) Retired() <-chan bool {
+	return obj.retired
+}
+
+// 连接全部关闭后可读
+func (obj *JzTransferPool) Closed() <-chan bool {
+	return obj.closed
+}
+
+func (obj *JzTransferPool) Retire() {
+	obj.retireOnce.Do(func() {
+		close(obj.retired)
+	})
+	obj.tryClose()
+}
+
+func (obj *JzTransferPool) tryClose() {
+	if len(obj.channel) < cap(obj.channel) {
+		return
+	}
+
+	obj.closeOnce.Do(obj.close)
+}
+
+// 退出时直接关闭所有连接
+func (obj *JzTransferPool) Stop() {
+	obj.retireOnce.Do(func() {
+		close(obj.retired)
+	})
+	obj.closeOnce.Do(obj.close)
+}
+
+// 并发关闭连接 主动关闭不发布目标状态事件
+func (obj *JzTransferPool) close() {
+	var wg sync.WaitGroup
+	for _, ts := range obj.conns {
+		wg.Add(1)
+		go func(ts *JzRsyncTarget) {
+			defer wg.Done()
+			ts.Stop()
+			ts.release()
+		}(ts)
+	}
+	wg.Wait()
+
+	close(obj.closed)
+}
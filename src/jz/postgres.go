@@ -0,0 +1,162 @@
+package jz
+
+import (
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"strings"
+	"time"
+)
+
+type JzPostgresDao struct {
+	*JzDao
+	source   string
+	channel  string
+	listener *pq.Listener
+	stopped  chan bool
+}
+
+func init() {
+	RegisterTaskSource("postgres", NewJzPostgresDao)
+}
+
+func NewJzPostgresDao(config *JzSourceConfig) (TaskSource, error) {
+	port := config.Port
+	if port == 0 {
+		port = 5432
+	}
+
+	sslmode := config.Sslmode
+	if len(sslmode) == 0 {
+		sslmode = "disable"
+	}
+
+	source := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		pqQuote(config.Ip),
+		port,
+		pqQuote(config.Username),
+		pqQuote(config.Password),
+		pqQuote(config.Database),
+		pqQuote(sslmode),
+	)
+
+	JzLogger.Printf("start connect to postgres server %s:%d/%s", config.Ip, port, config.Database)
+
+	dao, err := openJzDao(config, "postgres", source)
+	if err != nil {
+		return nil, err
+	}
+
+	err = dao.Setup()
+	if err != nil {
+		dao.Close()
+		return nil, err
+	}
+
+	return &JzPostgresDao{
+		JzDao:   dao,
+		source:  source,
+		channel: postgresChannel(config),
+		stopped: make(chan bool),
+	}, nil
+}
+
+func postgresChannel(config *JzSourceConfig) string {
+	if len(config.Channel) == 0 {
+		return "sync_files"
+	}
+
+	return config.Channel
+}
+
+// 按任务来源的表名 status列 待同步状态值及channel生成NOTIFY触发器
+func PostgresNotifySql(name string) (string, error) {
+	var config *JzSourceConfig
+	sources := RsyncConfig().Sources
+	for i := range sources {
+		if sources[i].Name == name && sources[i].Type == "postgres" {
+			config = &sources[i]
+		}
+	}
+
+	if config == nil {
+		return "", errors.New(fmt.Sprintf("postgres task source %s not found", name))
+	}
+
+	table := config.Table
+	notify := table.Name + "_notify"
+
+	return fmt.Sprintf(`CREATE OR REPLACE FUNCTION %s() RETURNS trigger AS $$
+BEGIN
+  PERFORM pg_notify('%s', NEW.%s::text);
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- 只在新增或重置为待同步时通知 同步过程中的状态及租约回写不触发
+DROP TRIGGER IF EXISTS %s ON %s;
+CREATE TRIGGER %s AFTER INSERT OR UPDATE OF %s ON %s
+  FOR EACH ROW WHEN (NEW.%s = %d) EXECUTE PROCEDURE %s();
+`,
+		notify, strings.Replace(postgresChannel(config), "'", "''", -1), table.Columns.Id,
+		notify, table.Name,
+		notify, table.Columns.Status, table.Name,
+		table.Columns.Status, table.Status.Pending, notify,
+	), nil
+}
+
+// 连接串中的值加单引号 其中的单引号及反斜杠需转义
+func pqQuote(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+// LISTEN指定channel 收到NOTIFY后立刻拉取任务
+func (dao *JzPostgresDao) Watch(rsync *JzRsync) {
+	dao.listener = pq.NewListener(dao.source, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			JzLogger.Printf("postgres %s listener event %d failed %v", dao.name, event, err)
+		}
+
+		//重连期间的通知会丢失
+		if event == pq.ListenerEventReconnected {
+			rsync.Pull()
+		}
+	})
+
+	err := dao.listener.Listen(dao.channel)
+	if err != nil {
+		JzLogger.Printf("postgres %s listen %s failed %v", dao.name, dao.channel, err)
+	}
+
+	go func() {
+		ping := time.NewTicker(time.Second * time.Duration(90))
+		defer ping.Stop()
+
+	L:
+		for {
+			select {
+			case <-dao.stopped:
+				break L
+			case n := <-dao.listener.Notify:
+				if n == nil {
+					continue
+				}
+				JzLogger.Printf("catch postgres %s notify %s %s", dao.name, n.Channel, n.Extra)
+				rsync.Pull()
+			case <-ping.C:
+				go dao.listener.Ping()
+			}
+		}
+
+		JzLogger.Printf("postgres %s listener exit", dao.name)
+	}()
+}
+
+func (dao *JzPostgresDao) Close() {
+	if dao.listener != nil {
+		close(dao.stopped)
+		dao.listener.Close()
+	}
+
+	dao.JzDao.Close()
+}
@@ -0,0 +1,57 @@
+package jz
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPqQuote(t *testing.T) {
+	cases := []struct {
+		value  string
+		expect string
+	}{
+		{"", `''`},
+		{"secret", `'secret'`},
+		{"it's", `'it\'s'`},
+		{`a\b`, `'a\\b'`},
+		{`a b\'c`, `'a b\\\'c'`},
+	}
+
+	for _, c := range cases {
+		if v := pqQuote(c.value); v != c.expect {
+			t.Errorf("pqQuote(%q) = %s, expect %s", c.value, v, c.expect)
+		}
+	}
+}
+
+// 触发器按配置的表名 status列 待同步状态值及channel生成
+func TestPostgresNotifySql(t *testing.T) {
+	setupTestConfig(t)
+
+	config := JzSourceConfig{Name: "files", Type: "postgres", Channel: "it's"}
+	config.Table.Name = "files"
+	config.Table.Columns.Status = "state"
+	config.Table.Status.Pending = 9
+	config.Table.SetDefaults()
+	RsyncConfig().Sources = []JzSourceConfig{config}
+
+	sql, err := PostgresNotifySql("files")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expect := range []string{
+		"pg_notify('it''s', NEW.id::text)",
+		"DROP TRIGGER IF EXISTS files_notify ON files;",
+		"AFTER INSERT OR UPDATE OF state ON files",
+		"WHEN (NEW.state = 9) EXECUTE PROCEDURE files_notify()",
+	} {
+		if !strings.Contains(sql, expect) {
+			t.Errorf("expect %s in %s", expect, sql)
+		}
+	}
+
+	if _, err := PostgresNotifySql("lost"); err == nil {
+		t.Fatal("expect lost source error")
+	}
+}
@@ -0,0 +1,183 @@
+package jz
+
+import (
+	"container/heap"
+	"container/list"
+	"sort"
+	"sync"
+)
+
+type jzQueueItem struct {
+	task    *JzTask
+	seq     int64
+	index   int
+	element *list.Element
+}
+
+type jzQueueHeap []*jzQueueItem
+
+func (h jzQueueHeap) Len() int {
+	return len(h)
+}
+
+func (h jzQueueHeap) Less(i, j int) bool {
+	if h[i].task.Priority != h[j].task.Priority {
+		return h[i].task.Priority > h[j].task.Priority
+	}
+
+	return h[i].seq < h[j].seq
+}
+
+func (h jzQueueHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *jzQueueHeap) Push(x interface{}) {
+	item := x.(*jzQueueItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *jzQueueHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
+
+// 按优先级出队的任务队列 每fairness次出队取一次等待最久的任务 避免低优先级任务饿死
+type JzTaskQueue struct {
+	sync.Mutex
+	items    jzQueueHeap
+	fifo     *list.List
+	seq      int64
+	popped   int
+	fairness int
+	ready    chan bool
+}
+
+func NewJzTaskQueue(fairness int) *JzTaskQueue {
+	return &JzTaskQueue{
+		items:    make(jzQueueHeap, 0),
+		fifo:     list.New(),
+		fairness: fairness,
+		ready:    make(chan bool, 1),
+	}
+}
+
+func (obj *JzTaskQueue) Push(t *JzTask) {
+	obj.Lock()
+	obj.seq++
+	item := &jzQueueItem{task: t, seq: obj.seq}
+	item.element = obj.fifo.PushBack(item)
+	heap.Push(&obj.items, item)
+	obj.Unlock()
+
+	select {
+	case obj.ready <- true:
+	default:
+	}
+}
+
+func (obj *JzTaskQueue) Pop() *JzTask {
+	obj.Lock()
+	defer obj.Unlock()
+
+	if len(obj.items) == 0 {
+		return nil
+	}
+
+	obj.popped++
+
+	var item *jzQueueItem
+	if obj.fairness > 0 && obj.popped%obj.fairness == 0 {
+		item = obj.fifo.Front().Value.(*jzQueueItem)
+		heap.Remove(&obj.items, item.index)
+	} else {
+		item = heap.Pop(&obj.items).(*jzQueueItem)
+	}
+	obj.fifo.Remove(item.element)
+
+	if len(obj.items) > 0 {
+		select {
+		case obj.ready <- true:
+		default:
+		}
+	}
+
+	return item.task
+}
+
+func (obj *JzTaskQueue) Len() int {
+	obj.Lock()
+	defer obj.Unlock()
+
+	return len(obj.items)
+}
+
+func (obj *JzTaskQueue) SetFairness(fairness int) {
+	obj.Lock()
+	defer obj.Unlock()
+
+	obj.fairness = fairness
+}
+
+// 按优先级顺序列出队列中从offset开始的count个任务
+func (obj *JzTaskQueue) List(offset int, count int) []*JzTask {
+	obj.Lock()
+	items := make(jzQueueHeap, len(obj.items))
+	copy(items, obj.items)
+	obj.Unlock()
+
+	sort.Slice(items, func(i, j int) bool {
+		return items.Less(i, j)
+	})
+
+	result := make([]*JzTask, 0)
+	for i := offset; i < len(items) && len(result) < count; i++ {
+		result = append(result, items[i].task)
+	}
+
+	return result
+}
+
+// 从队列中移除指定任务 不在队列中时返回nil
+func (obj *JzTaskQueue) Remove(key string) *JzTask {
+	obj.Lock()
+	defer obj.Unlock()
+
+	for _, item := range obj.items {
+		if item.task.Key() == key {
+			heap.Remove(&obj.items, item.index)
+			obj.fifo.Remove(item.element)
+			return item.task
+		}
+	}
+
+	return nil
+}
+
+// 清空队列 返回被移除的任务
+func (obj *JzTaskQueue) Flush() []*JzTask {
+	obj.Lock()
+	defer obj.Unlock()
+
+	result := make([]*JzTask, 0, len(obj.items))
+	for _, item := range obj.items {
+		result = append(result, item.task)
+	}
+
+	obj.items = make(jzQueueHeap, 0)
+	obj.fifo.Init()
+
+	return result
+}
+
+// 队列非空时可读
+func (obj *JzTaskQueue) Ready() <-chan bool {
+	return obj.ready
+}
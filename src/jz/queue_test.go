@@ -0,0 +1,108 @@
+package jz
+
+import (
+	"testing"
+)
+
+func testQueueTask(id int, priority int) *JzTask {
+	return &JzTask{Id: id, Priority: priority}
+}
+
+func testQueueIds(tasks []*JzTask) []int {
+	ids := make([]int, 0)
+	for _, task := range tasks {
+		ids = append(ids, task.Id)
+	}
+
+	return ids
+}
+
+func TestJzTaskQueuePop(t *testing.T) {
+	cases := []struct {
+		name       string
+		fairness   int
+		priorities []int
+		expect     []int
+	}{
+		{"fifo", 0, []int{0, 0, 0}, []int{1, 2, 3}},
+		{"priority", 0, []int{1, 5, 3, 5}, []int{2, 4, 3, 1}},
+		{"fairness", 3, []int{0, 9, 9, 9, 9}, []int{2, 3, 1, 4, 5}},
+		{"fairness every pop", 1, []int{0, 9, 5}, []int{1, 2, 3}},
+	}
+
+	for _, c := range cases {
+		queue := NewJzTaskQueue(c.fairness)
+		for i, priority := range c.priorities {
+			queue.Push(testQueueTask(i+1, priority))
+		}
+
+		ids := make([]int, 0)
+		for task := queue.Pop(); task != nil; task = queue.Pop() {
+			ids = append(ids, task.Id)
+		}
+
+		if len(ids) != len(c.expect) {
+			t.Errorf("%s: pop %v, expect %v", c.name, ids, c.expect)
+			continue
+		}
+
+		for i := range ids {
+			if ids[i] != c.expect[i] {
+				t.Errorf("%s: pop %v, expect %v", c.name, ids, c.expect)
+				break
+			}
+		}
+	}
+}
+
+func TestJzTaskQueueRemove(t *testing.T) {
+	queue := NewJzTaskQueue(2)
+	for i, priority := range []int{0, 3, 1, 2} {
+		queue.Push(testQueueTask(i+1, priority))
+	}
+
+	if task := queue.Remove(testQueueTask(1, 0).Key()); task == nil || task.Id != 1 {
+		t.Fatalf("expect remove task 1, got %v", task)
+	}
+
+	if task := queue.Remove(testQueueTask(9, 0).Key()); task != nil {
+		t.Fatalf("expect no task, got %v", task)
+	}
+
+	if ids := testQueueIds(queue.List(1, 10)); len(ids) != 2 || ids[0] != 4 || ids[1] != 3 {
+		t.Fatalf("expect list [4 3], got %v", ids)
+	}
+
+	//被移除的任务不再作为等待最久的任务出队
+	ids := []int{queue.Pop().Id, queue.Pop().Id}
+	if ids[0] != 2 || ids[1] != 3 {
+		t.Fatalf("expect pop [2 3], got %v", ids)
+	}
+
+	if queue.Len() != 1 || len(queue.Flush()) != 1 || queue.Pop() != nil {
+		t.Errorf("expect empty queue after flush")
+	}
+}
+
+func TestJzTaskQueueReady(t *testing.T) {
+	queue := NewJzTaskQueue(0)
+	queue.Push(testQueueTask(1, 0))
+	queue.Push(testQueueTask(2, 0))
+
+	<-queue.Ready()
+	queue.Pop()
+
+	select {
+	case <-queue.Ready():
+	default:
+		t.Fatal("expect ready while queue not empty")
+	}
+
+	queue.Pop()
+
+	select {
+	case <-queue.Ready():
+		t.Fatal("expect not ready on empty queue")
+	default:
+	}
+}
@@ -0,0 +1,266 @@
+package jz
+
+import (
+	"fmt"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 重新读取配置文件 与运行中的配置比较后应用 返回变更说明
+// 监听地址 实例标识 选主及记录配置的变更需重启后生效 保留原值
+func (obj *JzRsync) Reload() ([]string, error) {
+	config, err := LoadXmlConfig(jzRsyncConfigFile)
+	if err != nil {
+		return nil, err
+	}
+
+	obj.reloadLock.Lock()
+	defer obj.reloadLock.Unlock()
+
+	old := RsyncConfig()
+	changes := make([]string, 0)
+
+	restart := func(name string) {
+		changes = append(changes, fmt.Sprintf("%s changed, restart required", name))
+	}
+
+	if config.Address != old.Address {
+		restart("address")
+		config.Address = old.Address
+	}
+
+	if config.Socket != old.Socket {
+		restart("socket")
+		config.Socket = old.Socket
+	}
+
+	if config.Instance != old.Instance {
+		restart("instance")
+		config.Instance = old.Instance
+	}
+
+	if !reflect.DeepEqual(config.Leader, old.Leader) {
+		restart("leader")
+		config.Leader = old.Leader
+	}
+
+	if !reflect.DeepEqual(config.Audit, old.Audit) {
+		restart("audit")
+		config.Audit = old.Audit
+	}
+
+	//未启用认证及socket时redis服务未在校验权限的监听上运行 无法开启认证
+	if !reflect.DeepEqual(config.Auth, old.Auth) {
+		if config.Auth.Enabled() && !old.Auth.Enabled() && len(old.Socket) == 0 {
+			restart("auth")
+			config.Auth = old.Auth
+		} else {
+			changes = append(changes, "auth")
+		}
+	}
+
+	if config.Repertory != old.Repertory {
+		changes = append(changes, fmt.Sprintf("repertory %s", config.Repertory))
+	}
+
+	if config.Interval != old.Interval {
+		select {
+		case <-obj.intervalChanged:
+		default:
+		}
+		obj.intervalChanged <- config.Interval
+		changes = append(changes, fmt.Sprintf("interval %d", config.Interval))
+	}
+
+	if config.Fairness != old.Fairness {
+		obj.queue.SetFairness(config.Fairness)
+		changes = append(changes, fmt.Sprintf("fairness %d", config.Fairness))
+	}
+
+	if config.Batch != old.Batch {
+		changes = append(changes, fmt.Sprintf("batch %d", config.Batch))
+	}
+
+	//配置文件中的目标未变时保留target命令的修改
+	if !SameTargets(config.TargetServer, old.TargetServer) {
+		modified := !SameTargets(old.TargetServer, obj.Pool().Servers())
+		_, err := obj.UpdateTargets(func(servers []JzTargetServer) ([]JzTargetServer, error) {
+			return config.TargetServer, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		changes = append(changes, fmt.Sprintf("targets %d", len(config.TargetServer)))
+		if modified {
+			changes = append(changes, "targets changed by target command discarded")
+		}
+	}
+
+	changes = append(changes, obj.reloadSources(old, config)...)
+
+	setRsyncConfig(config)
+
+	JzLogger.Printf("reload config %s %v", jzRsyncConfigFile, changes)
+
+	return changes, nil
+}
+
+// 按名称比较任务来源 新增或修改的来源重新创建 被替换的来源在其任务全部结束后关闭
+func (obj *JzRsync) reloadSources(old *JzRsyncConfig, config *JzRsyncConfig) []string {
+	changes := make([]string, 0)
+
+	oldConfigs := make(map[string]*JzSourceConfig)
+	for i := range old.Sources {
+		oldConfigs[old.Sources[i].Name] = &old.Sources[i]
+	}
+
+	//选主及记录使用的来源不能替换
+	pinned := []string{config.Leader.Source}
+	if config.Audit.Type == "db" {
+		pinned = append(pinned, config.Audit.Source)
+	}
+
+	sources := make([]TaskSource, 0)
+	retired := make([]TaskSource, 0)
+	added := make([]TaskSource, 0)
+	names := make([]string, 0)
+
+	for i := range config.Sources {
+		c := &config.Sources[i]
+		names = append(names, c.Name)
+
+		current := obj.Source(c.Name)
+		oldConfig, ok := oldConfigs[c.Name]
+		if ok && current != nil && reflect.DeepEqual(oldConfig, c) {
+			sources = append(sources, current)
+			continue
+		}
+
+		if current != nil && InStringArray(c.Name, pinned) {
+			changes = append(changes, fmt.Sprintf("source %s changed, restart required", c.Name))
+			*c = *oldConfig
+			sources = append(sources, current)
+			continue
+		}
+
+		source, err := NewTaskSource(c)
+		if err != nil {
+			JzLogger.Printf("reload task source %s failed %v", c.Name, err)
+			changes = append(changes, fmt.Sprintf("source %s failed %v", c.Name, err))
+			if current != nil {
+				*c = *oldConfig
+				sources = append(sources, current)
+			}
+			continue
+		}
+
+		if current != nil {
+			retired = append(retired, current)
+		}
+
+		sources = append(sources, source)
+		added = append(added, source)
+		changes = append(changes, fmt.Sprintf("source %s", c.Name))
+	}
+
+	for _, source := range obj.Sources() {
+		if InStringArray(source.Name(), names) {
+			continue
+		}
+
+		if InStringArray(source.Name(), pinned) {
+			changes = append(changes, fmt.Sprintf("source %s removed, restart required", source.Name()))
+			config.Sources = append(config.Sources, *oldConfigs[source.Name()])
+			sources = append(sources, source)
+			continue
+		}
+
+		retired = append(retired, source)
+		changes = append(changes, fmt.Sprintf("source %s removed", source.Name()))
+	}
+
+	//先停止被替换来源的binlog及游标保存 避免与新的来源同时消费或覆盖同一游标文件
+	for _, source := range retired {
+		if s, ok := source.(DaoTaskSource); ok {
+			s.Dao().Retire()
+		}
+	}
+
+	obj.sourceLock.Lock()
+	obj.sources = sources
+	obj.sourceLock.Unlock()
+
+	for _, source := range added {
+		if watcher, ok := source.(TaskWatcher); ok {
+			watcher.Watch(obj)
+		}
+	}
+
+	for _, source := range retired {
+		go obj.retireSource(source)
+	}
+
+	return changes
+}
+
+// 移除选主回调 等待来自该来源的任务全部结束后关闭
+func (obj *JzRsync) retireSource(source TaskSource) {
+	if s, ok := source.(DaoTaskSource); ok && obj.leader != nil {
+		obj.leader.Unregister(s.Dao())
+	}
+
+	for GlobalData.Tasks.Using(source) {
+		time.Sleep(time.Second)
+	}
+
+	JzLogger.Printf("close replaced task source %s", source.Name())
+	source.Close()
+}
+
+// 运行中的配置 依次为名称及值 pattern规则同path.Match 不含密码
+func (obj *JzRsync) ConfigValues(pattern string) []string {
+	config := RsyncConfig()
+
+	targets := make([]string, 0)
+	for _, target := range obj.Pool().Servers() {
+		targets = append(targets, fmt.Sprintf("%s@%s[%s]", target.Name, target.Address, target.Group.ToString()))
+	}
+
+	sources := make([]string, 0)
+	for i := range config.Sources {
+		sources = append(sources, fmt.Sprintf("%s:%s", config.Sources[i].Name, config.Sources[i].Type))
+	}
+
+	auth := "off"
+	if config.Auth.Enabled() {
+		auth = "on"
+	}
+
+	values := []string{
+		"address", config.Address,
+		"socket", config.Socket,
+		"instance", config.Instance,
+		"repertory", config.Repertory,
+		"interval", strconv.Itoa(config.Interval),
+		"fairness", strconv.Itoa(config.Fairness),
+		"batch", strconv.Itoa(config.Batch),
+		"targets", strings.Join(targets, ","),
+		"sources", strings.Join(sources, ","),
+		"leader", config.Leader.Source,
+		"audit", config.Audit.Type,
+		"auth", auth,
+	}
+
+	result := make([]string, 0)
+	for i := 0; i < len(values); i += 2 {
+		if ok, _ := path.Match(strings.ToLower(pattern), values[i]); ok {
+			result = append(result, values[i], values[i+1])
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,224 @@
+package jz
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 与redis相同的上限 未认证的连接使用较小的上限
+const (
+	RESP_MAX_ARGS        = 1024 * 1024
+	RESP_MAX_BULK        = 512 * 1024 * 1024
+	RESP_MAX_INLINE      = 64 * 1024
+	RESP_MAX_UNAUTH_ARGS = 16
+	RESP_MAX_UNAUTH_BULK = 1024
+)
+
+// redis服务端返回的错误
+type RespError string
+
+func (e RespError) Error() string {
+	return string(e)
+}
+
+// 客户端发送的数据不符合协议或超出上限
+type RespProtocolError string
+
+func (e RespProtocolError) Error() string {
+	return "Protocol error: " + string(e)
+}
+
+// 读取以\r\n结尾的一行 超过limit返回协议错误
+func readRespLine(r *bufio.Reader, limit int) (string, error) {
+	line := make([]byte, 0)
+	for {
+		chunk, err := r.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > limit || (err == bufio.ErrBufferFull && len(line) == limit) {
+			return "", RespProtocolError("too big line")
+		}
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		return string(line), nil
+	}
+}
+
+type JzRespConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func DialResp(address string, password string, database string) (*JzRespConn, error) {
+	conn, err := net.DialTimeout("tcp", address, time.Second*time.Duration(10))
+	if err != nil {
+		return nil, err
+	}
+
+	c := &JzRespConn{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+	}
+
+	if len(password) > 0 {
+		if _, err := c.Do("AUTH", password); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	if len(database) > 0 {
+		if _, err := c.Do("SELECT", database); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+func (c *JzRespConn) Do(args ...string) (interface{}, error) {
+	c.conn.SetDeadline(time.Now().Add(time.Second * time.Duration(30)))
+
+	_, err := c.conn.Write(EncodeRespCommand(args...))
+	if err != nil {
+		return nil, err
+	}
+
+	return ReadRespValue(c.reader)
+}
+
+func (c *JzRespConn) Close() {
+	c.conn.Close()
+}
+
+func EncodeRespCommand(args ...string) []byte {
+	buf := []byte(fmt.Sprintf("*%d\r\n", len(args)))
+	for _, arg := range args {
+		buf = append(buf, fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)...)
+	}
+
+	return buf
+}
+
+// 读取一个完整的resp值 bulk为string 数组为[]interface{} 服务端错误以RespError返回
+func ReadRespValue(r *bufio.Reader) (interface{}, error) {
+	return readRespValue(r, RESP_MAX_ARGS, RESP_MAX_BULK)
+}
+
+// 长度为负或超出上限时返回协议错误 不按声明的长度预先分配
+func readRespValue(r *bufio.Reader, maxArgs int, maxBulk int) (interface{}, error) {
+	line, err := readRespLine(r, RESP_MAX_INLINE)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New(fmt.Sprintf("error resp line %q", line))
+	}
+
+	body := line[1 : len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, RespError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < -1 || n > maxBulk {
+			return nil, RespProtocolError("invalid bulk length")
+		}
+
+		if n < 0 {
+			return nil, nil
+		}
+
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < -1 || n > maxArgs {
+			return nil, RespProtocolError("invalid multibulk length")
+		}
+
+		if n < 0 {
+			return nil, nil
+		}
+
+		values := make([]interface{}, 0)
+		for i := 0; i < n; i++ {
+			v, err := readRespValue(r, maxArgs, maxBulk)
+			if re, ok := err.(RespError); ok {
+				v = re
+			} else if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+
+		return values, nil
+	}
+
+	return nil, errors.New(fmt.Sprintf("unknown resp type %q", line[0]))
+}
+
+// 读取客户端命令 支持数组及inline格式 maxArgs及maxBulk限制参数个数及长度
+func ReadRespCommand(r *bufio.Reader, maxArgs int, maxBulk int) ([]string, error) {
+	line, err := readRespLine(r, RESP_MAX_INLINE)
+	if err != nil {
+		return nil, err
+	}
+
+	if line[0] != '*' {
+		return strings.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(strings.TrimRight(line[1:], "\r\n"))
+	if err != nil || n > maxArgs {
+		return nil, RespProtocolError("invalid multibulk length")
+	}
+
+	args := make([]string, 0)
+	for i := 0; i < n; i++ {
+		line, err := readRespLine(r, RESP_MAX_INLINE)
+		if err != nil {
+			return nil, err
+		}
+
+		if line[0] != '$' {
+			return nil, RespProtocolError(fmt.Sprintf("expected '$', got '%c'", line[0]))
+		}
+
+		size, err := strconv.Atoi(strings.TrimRight(line[1:], "\r\n"))
+		if err != nil || size < 0 || size > maxBulk {
+			return nil, RespProtocolError("invalid bulk length")
+		}
+
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+
+		args = append(args, string(data[:size]))
+	}
+
+	return args, nil
+}
@@ -0,0 +1,67 @@
+package jz
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEncodeRespCommand(t *testing.T) {
+	data := string(EncodeRespCommand("SET", "cdn", "a b.jpg", ""))
+	expect := "*4\r\n$3\r\nSET\r\n$3\r\ncdn\r\n$7\r\na b.jpg\r\n$0\r\n\r\n"
+	if data != expect {
+		t.Errorf("EncodeRespCommand = %q, expect %q", data, expect)
+	}
+}
+
+func TestReadRespValue(t *testing.T) {
+	cases := []struct {
+		data   string
+		expect interface{}
+		err    string
+	}{
+		{"+OK\r\n", "OK", ""},
+		{"-ERR wrong\r\n", nil, "ERR wrong"},
+		{":42\r\n", int64(42), ""},
+		{"$5\r\nhello\r\n", "hello", ""},
+		{"$0\r\n\r\n", "", ""},
+		{"$-1\r\n", nil, ""},
+		{"*-1\r\n", nil, ""},
+		{"*0\r\n", []interface{}{}, ""},
+		{"*3\r\n$1\r\na\r\n:1\r\n*1\r\n$2\r\n\r\n\r\n", []interface{}{"a", int64(1), []interface{}{"\r\n"}}, ""},
+		{"*2\r\n-NOGROUP\r\n+OK\r\n", []interface{}{RespError("NOGROUP"), "OK"}, ""},
+		{"OK\n", nil, "error resp line"},
+		{"?x\r\n", nil, "unknown resp type"},
+		{"$5\r\nhel", nil, "EOF"},
+		{"*9999999999999\r\n", nil, "Protocol error"},
+		{"$-2\r\n", nil, "Protocol error"},
+		{"$x\r\n", nil, "Protocol error"},
+	}
+
+	for _, c := range cases {
+		v, err := ReadRespValue(bufio.NewReader(strings.NewReader(c.data)))
+		if len(c.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("ReadRespValue(%q) error %v, expect %s", c.data, err, c.err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("ReadRespValue(%q) error %v", c.data, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(v, c.expect) {
+			t.Errorf("ReadRespValue(%q) = %#v, expect %#v", c.data, v, c.expect)
+		}
+	}
+}
+
+func TestReadRespValueServerError(t *testing.T) {
+	_, err := ReadRespValue(bufio.NewReader(strings.NewReader("-BUSYGROUP exists\r\n")))
+	if _, ok := err.(RespError); !ok {
+		t.Errorf("expect RespError, got %T", err)
+	}
+}
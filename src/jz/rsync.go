@@ -2,6 +2,7 @@ package jz
 
 import (
 	"sync"
+	"sync/atomic"
 	"net"
 	"fmt"
 	"strings"
@@ -21,10 +22,12 @@ type JzRsyncTarget struct {
 	connStopped  chan bool
 	stopped      chan bool
 	Name         string
+	connected    int32
+	latency      int64
 }
 
 func (obj *JzRsyncTarget) Connect() (error) {
-	conn, err := net.Dial("tcp", obj.Target.Address)
+	conn, err := net.DialTimeout("tcp", obj.Target.Address, time.Second*time.Duration(10))
 	if err != nil {
 		JzLogger.Printf("connecting target server %s[%s] failed %s", obj.Target.Name, obj.Target.Address, err)
 		return err
@@ -84,9 +87,14 @@ func (obj *JzRsyncTarget) Start() {
 	obj.connStopped = make(chan bool, 1)
 	obj.stopped = make(chan bool, 1)
 
-	obj.Connect()
-
 	go func() {
+		//在后台建立首个连接 启动时即不可达的目标也需发布down
+		obj.Lock()
+		if obj.tryConnect && obj.Connect() != nil {
+			obj.setConnected(false)
+		}
+		obj.Unlock()
+
 		interval := time.NewTicker(time.Second * time.Duration(5))
 		defer interval.Stop()
 
@@ -104,19 +112,24 @@ func (obj *JzRsyncTarget) Start() {
 					if obj.tryConnect {
 						err := obj.Connect()
 						if err != nil {
+							obj.setConnected(false)
 							JzLogger.Printf("[%s]reconnect target server %s[%s] failed %s", obj.localAddress, obj.Target.Name, obj.Target.Address, err)
 							return
 						}
 						obj.tryConnect = false
 					}
 
+					pingTime := time.Now()
 					obj.WriteAll([]byte("PING\r\n"))
 
 					message, err := obj.ReadAll(6)
 					if err == nil && len(message) >= 6 {
+						atomic.StoreInt64(&obj.latency, int64(time.Since(pingTime)))
+						obj.setConnected(true)
 						//JzLogger.Printf("[%s]ping %s[%s] got %s", obj.localAddress, obj.Target.Name, obj.Target.Address, strings.Trim(string(message), "\r\n"))
 					} else {
 						obj.tryConnect = true
+						obj.setConnected(false)
 						JzLogger.Printf("[%s]ping %s[%s] failed %v", obj.localAddress, obj.Target.Name, obj.Target.Address, err)
 					}
 				}()
@@ -127,24 +140,65 @@ func (obj *JzRsyncTarget) Start() {
 	}()
 }
 
+func (obj *JzRsyncTarget) setConnected(connected bool) {
+	state := CONNECTION_DOWN
+	if connected {
+		state = CONNECTION_UP
+	}
+
+	if old := atomic.SwapInt32(&obj.connected, state); old != state {
+		TargetConnectionChanged(obj.Target, old, state)
+	}
+}
+
+// 最近一次心跳是否成功
+func (obj *JzRsyncTarget) Connected() bool {
+	return atomic.LoadInt32(&obj.connected) == CONNECTION_UP
+}
+
+// 最近一次成功心跳的耗时
+func (obj *JzRsyncTarget) Latency() time.Duration {
+	return time.Duration(atomic.LoadInt64(&obj.latency))
+}
+
 func (obj *JzRsyncTarget) Stop() {
 	JzLogger.Printf("[%s]send stopped signal to %s[%s]", obj.localAddress, obj.Target.Name, obj.Target.Address)
 	obj.connStopped <- true
 	<-obj.stopped
+
+	obj.Lock()
+	if obj.conn != nil {
+		obj.conn.Close()
+	}
+	obj.Unlock()
+
 	JzLogger.Printf("[%s]send stopped signal to %s[%s] success", obj.localAddress, obj.Target.Name, obj.Target.Address)
 }
 
-func (obj *JzRsyncTarget) Rsync(t *JzTask, num int) (bool, error) {
+// 主动关闭的连接不再计入目标状态 不发布事件
+func (obj *JzRsyncTarget) release() {
+	if old := atomic.SwapInt32(&obj.connected, CONNECTION_UNKNOWN); old != CONNECTION_UNKNOWN {
+		TargetConnectionChanged(obj.Target, old, CONNECTION_UNKNOWN)
+	}
+}
+
+func (obj *JzRsyncTarget) Rsync(t *JzTask, num int) (bool, int, int64, error) {
 	loop := 0
+	var total int64
+	var lastErr error
 
 	for {
 		if loop > num {
-			return false, errors.New(fmt.Sprintf("[%s]rsync %s to server %s[%s] failed", obj.localAddress, t.Path, obj.Target.Name, obj.Target.Address))
+			return false, loop, total, errors.New(fmt.Sprintf("[%s]rsync %s to server %s[%s] failed %v", obj.localAddress, t.Path, obj.Target.Name, obj.Target.Address, lastErr))
 		}
 
 		loop++
-		ok, err := obj.RsyncOnce(t)
+		startTime := time.Now()
+		ok, n, err := obj.RsyncOnce(t)
+		total += n
+		Audit(t, obj.Target, n, time.Since(startTime), ok, err)
 		if err != nil {
+			lastErr = err
 			if err != io.EOF {
 				JzLogger.Print(err)
 			}
@@ -156,11 +210,11 @@ func (obj *JzRsyncTarget) Rsync(t *JzTask, num int) (bool, error) {
 			continue
 		}
 
-		return true, nil
+		return true, loop, total, nil
 	}
 }
 
-func (obj *JzRsyncTarget) RsyncOnce(t *JzTask) (bool, error) {
+func (obj *JzRsyncTarget) RsyncOnce(t *JzTask) (bool, int64, error) {
 	obj.Lock()
 	defer obj.Unlock()
 
@@ -168,7 +222,7 @@ func (obj *JzRsyncTarget) RsyncOnce(t *JzTask) (bool, error) {
 		err := obj.Connect()
 		if err != nil {
 			JzLogger.Printf("[%s]reconnect target server %s[%s] failed %s", obj.localAddress, obj.Target.Name, obj.Target.Address, err)
-			return false, err
+			return false, 0, err
 		}
 		obj.tryConnect = false
 	}
@@ -176,7 +230,7 @@ func (obj *JzRsyncTarget) RsyncOnce(t *JzTask) (bool, error) {
 	f, err := os.Open(t.Path)
 	if err != nil {
 		JzLogger.Printf("[%s]Open file %s failed %v", obj.localAddress, t.AbsolutePath, err)
-		return false, err
+		return false, 0, err
 	}
 	defer f.Close()
 
@@ -191,18 +245,18 @@ func (obj *JzRsyncTarget) RsyncOnce(t *JzTask) (bool, error) {
 		rr := strings.Trim(string(message), "\r\n")
 		if rr == "ALL_SAME" {
 			JzLogger.Printf("[%s]Transfer %s to server %s[%s] success", obj.localAddress, t.Path, obj.Target.Name, obj.Target.Address)
-			return true, nil
+			return true, 0, nil
 		}
 
 		if rr != "CONTINUE" {
 			obj.tryConnect = true
 			JzLogger.Printf("[%s]Read Transfer %s to server %s[%s] header response [%s] failed %s", obj.localAddress, t.Path, obj.Target.Name, obj.Target.Address, rr, err)
-			return false, errors.New("error transfer header response")
+			return false, 0, errors.New("error transfer header response")
 		}
 	} else {
 		obj.tryConnect = true
 		JzLogger.Printf("[%s]Read Transfer %s to server %s[%s] header response failed %s", obj.localAddress, t.Path, obj.Target.Name, obj.Target.Address, err)
-		return false, err
+		return false, 0, err
 	}
 
 	buf := make([]byte, 1024)
@@ -233,59 +287,474 @@ func (obj *JzRsyncTarget) RsyncOnce(t *JzTask) (bool, error) {
 		rr := strings.Trim(string(message), "\r\n")
 		if rr == "OK" {
 			JzLogger.Printf("[%s]Transfer %s to server %s[%s] success", obj.localAddress, t.Path, obj.Target.Name, obj.Target.Address)
-			return true, nil
+			return true, int64(total), nil
 		}
 	}
 
 	obj.tryConnect = true
 	JzLogger.Printf("[%s]Read Transfer %s to server %s[%s] failed %s", obj.localAddress, t.Path, obj.Target.Name, obj.Target.Address, err)
 
-	return false, errors.New("error transfer header response")
+	return false, int64(total), errors.New("error transfer header response")
 }
 
 type JzRsync struct {
 	stopped           chan bool
 	taskToStopped     chan bool
 	intervalToStopped chan bool
-	queue             chan *JzTask
-	transferChannel   chan []*JzRsyncTarget
-	allTargetServer   []*JzRsyncTarget
-	AllTargetHostNames []string
+	queue             *JzTaskQueue
+	pool              *JzTransferPool
+	poolLock          sync.RWMutex
+	paused            map[string]bool
+	held              []*JzTask
+	syncing           map[string]int
+	idle              map[string][]chan bool
+	targetLock        sync.Mutex
+	sources           []TaskSource
+	sourceLock        sync.RWMutex
+	reloadLock        sync.Mutex
+	intervalChanged   chan int
+	scheduler         *JzTimerWheel
+	newTask           chan bool
+	leader            *JzLeader
+	more              int32
+	started           time.Time
+	lastPoll          int64
+	files             int64
+	bytes             int64
+	failures          int64
 }
 
-func (obj *JzRsync) Init() {
+func (obj *JzRsync) Init() error {
+	config := RsyncConfig()
+
+	obj.started = time.Now()
 	obj.stopped = make(chan bool, 2)
 	obj.taskToStopped = make(chan bool, 1)
 	obj.intervalToStopped = make(chan bool, 1)
-	obj.queue = make(chan *JzTask, 1024)
+	obj.intervalChanged = make(chan int, 1)
+	obj.newTask = make(chan bool, 1)
+	obj.queue = NewJzTaskQueue(config.Fairness)
+
+	obj.scheduler = NewJzTimerWheel(3600, config.Schedule, obj.sendScheduled)
+	err := obj.scheduler.Load()
+	if err != nil {
+		return errors.New(fmt.Sprintf("load scheduled tasks from %s failed %v", config.Schedule, err))
+	}
+
+	for i := range config.Sources {
+		source, err := NewTaskSource(&config.Sources[i])
+		if err != nil {
+			for _, s := range obj.sources {
+				s.Close()
+			}
+			return err
+		}
+
+		obj.sources = append(obj.sources, source)
+	}
+
+	//重新加载时同名来源仍有同步中的任务 不能恢复
+	for _, source := range obj.sources {
+		if s, ok := source.(DaoTaskSource); ok {
+			if err := s.Dao().recoverLeases(); err != nil {
+				for _, s := range obj.sources {
+					s.Close()
+				}
+				return err
+			}
+		}
+	}
+
+	if len(config.Audit.Type) > 0 {
+		auditor, err := NewJzAuditor(&config.Audit, obj.sources)
+		if err != nil {
+			for _, s := range obj.sources {
+				s.Close()
+			}
+			return err
+		}
+
+		GlobalData.Auditor = auditor
+	}
+
+	if len(config.Leader.Source) > 0 {
+		source, ok := obj.Source(config.Leader.Source).(DaoTaskSource)
+		if !ok {
+			for _, s := range obj.sources {
+				s.Close()
+			}
+			return errors.New(fmt.Sprintf("task source %s not support leader election", config.Leader.Source))
+		}
+
+		obj.leader = NewJzLeader(source.Dao(), &config.Leader)
+	}
+
+	obj.paused = make(map[string]bool)
+	obj.syncing = make(map[string]int)
+	obj.idle = make(map[string][]chan bool)
+	obj.pool = NewJzTransferPool(config.TargetServer)
+
+	return nil
+}
+
+func (obj *JzRsync) Pool() *JzTransferPool {
+	obj.poolLock.RLock()
+	defer obj.poolLock.RUnlock()
+
+	return obj.pool
+}
+
+// 所有目标的组名
+func (obj *JzRsync) HostNames() []string {
+	return obj.Pool().HostNames()
+}
+
+// 按新的目标列表重建传输通道池 同步中的任务继续使用旧的池 全部结束后关闭旧的连接
+func (obj *JzRsync) Rebuild(servers []JzTargetServer) *JzTransferPool {
+	pool := NewJzTransferPool(servers)
 
-	transferTargetNumber := len(jzRsyncConfig.TargetServer)
-	transferChannelNumber := transferTargetNumber * 10
+	obj.poolLock.Lock()
+	old := obj.pool
+	obj.pool = pool
+	obj.poolLock.Unlock()
+
+	old.Retire()
+
+	JzLogger.Printf("rebuild transfer pool with %d targets", len(servers))
+
+	//暂停的目标可能已被移除
+	obj.requeueHeld()
+
+	return old
+}
+
+// 修改目标列表并重建传输通道池 返回被替换的池
+func (obj *JzRsync) UpdateTargets(update func(servers []JzTargetServer) ([]JzTargetServer, error)) (*JzTransferPool, error) {
+	obj.targetLock.Lock()
+	defer obj.targetLock.Unlock()
+
+	servers, err := update(obj.Pool().Servers())
+	if err != nil {
+		return nil, err
+	}
 
-	obj.transferChannel = make(chan []*JzRsyncTarget, transferChannelNumber)
-	obj.AllTargetHostNames = make([]string, transferTargetNumber)
+	//没有目标时无法取得传输通道
+	if len(servers) == 0 {
+		return nil, ERR_LAST_TARGET
+	}
 
-	for n := 0; n < transferChannelNumber; n++ {
-		target := make([]*JzRsyncTarget, transferTargetNumber)
-		for i := 0; i < transferTargetNumber; i++ {
-			target[i] = &JzRsyncTarget{Target: &jzRsyncConfig.TargetServer[i]}
-			target[i].Start()
-			target[i].Name = fmt.Sprintf("%d-%d", n, i)
-			obj.allTargetServer = append(obj.allTargetServer, target[i])
+	return obj.Rebuild(servers), nil
+}
 
-			if n == 0 {
-				obj.AllTargetHostNames = append(obj.AllTargetHostNames, jzRsyncConfig.TargetServer[i].Group...)
+// 取得当前池的空闲传输通道 等待期间池被替换时改为等待新的池 收到停止信号时返回false
+func (obj *JzRsync) acquire() (*JzTransferPool, []*JzRsyncTarget, bool) {
+	for {
+		pool := obj.Pool()
+		select {
+		case targetServer := <-pool.channel:
+			select {
+			case <-pool.Retired():
+				pool.Release(targetServer)
+				continue
+			default:
 			}
+			return pool, targetServer, true
+		case <-pool.Retired():
+		case <-obj.taskToStopped:
+			return nil, nil, false
+		}
+	}
+}
+
+// 恢复目标后重新同步挂起的任务
+func (obj *JzRsync) Pause(name string, paused bool) {
+	obj.poolLock.Lock()
+	if paused {
+		obj.paused[name] = true
+	} else {
+		delete(obj.paused, name)
+	}
+	obj.poolLock.Unlock()
+
+	if !paused {
+		obj.requeueHeld()
+	}
+}
+
+// 需同步到暂停目标的任务在其他目标完成后挂起 目标恢复时重新入队
+func (obj *JzRsync) hold(task *JzTask, targets []string) {
+	obj.poolLock.Lock()
+	paused := false
+	for _, name := range targets {
+		if obj.paused[name] {
+			paused = true
+		}
+	}
+
+	if paused {
+		task.SetState(TASK_PAUSED)
+		obj.held = append(obj.held, task)
+	}
+	obj.poolLock.Unlock()
+
+	if paused {
+		JzLogger.Printf("task %s held for paused targets %v", task.Key(), targets)
+		return
+	}
+
+	//挂起前目标已恢复
+	obj.Send(task)
+}
+
+func (obj *JzRsync) requeueHeld() {
+	obj.poolLock.Lock()
+	held := obj.held
+	obj.held = nil
+	obj.poolLock.Unlock()
+
+	for _, task := range held {
+		JzLogger.Printf("requeue held task %s", task.Key())
+		obj.Send(task)
+	}
+}
+
+// 移除挂起的任务 不存在时返回nil
+func (obj *JzRsync) removeHeld(key string) *JzTask {
+	obj.poolLock.Lock()
+	defer obj.poolLock.Unlock()
+
+	for i, task := range obj.held {
+		if task.Key() == key {
+			obj.held = append(obj.held[:i], obj.held[i+1:]...)
+			return task
 		}
-		obj.transferChannel <- target
 	}
+
+	return nil
+}
+
+// 挂起的任务数
+func (obj *JzRsync) Held() int {
+	obj.poolLock.RLock()
+	defer obj.poolLock.RUnlock()
+
+	return len(obj.held)
+}
+
+func (obj *JzRsync) Paused(name string) bool {
+	obj.poolLock.RLock()
+	defer obj.poolLock.RUnlock()
+
+	return obj.paused[name]
+}
+
+// 目标未暂停时记为同步中 与暂停互斥 暂停后不再开始新的同步
+func (obj *JzRsync) begin(name string) bool {
+	obj.poolLock.Lock()
+	defer obj.poolLock.Unlock()
+
+	if obj.paused[name] {
+		return false
+	}
+
+	obj.syncing[name] += 1
+	return true
+}
+
+func (obj *JzRsync) end(name string) {
+	obj.poolLock.Lock()
+	defer obj.poolLock.Unlock()
+
+	obj.syncing[name] -= 1
+	if obj.syncing[name] > 0 {
+		return
+	}
+
+	delete(obj.syncing, name)
+	for _, c := range obj.idle[name] {
+		close(c)
+	}
+	delete(obj.idle, name)
+}
+
+// 目标没有同步中的任务时可读
+func (obj *JzRsync) Idle(name string) <-chan bool {
+	obj.poolLock.Lock()
+	defer obj.poolLock.Unlock()
+
+	c := make(chan bool)
+	if obj.syncing[name] == 0 {
+		close(c)
+		return c
+	}
+
+	obj.idle[name] = append(obj.idle[name], c)
+	return c
 }
 
 func (obj *JzRsync) Send(t *JzTask) (bool, error) {
-	obj.queue <- t
+	if t.Id == 0 && t.Source == nil {
+		t.Id = GlobalData.Tasks.NextId()
+	}
+
+	t.SetState(TASK_QUEUED)
+	GlobalData.Tasks.Track(t)
+	obj.queue.Push(t)
 	return true, nil
 }
 
+// 校验文件后提交任务
+func (obj *JzRsync) SendFile(hostNames []string, file string, md5sum string, priority int) (*JzTask, error) {
+	task, err := AssembleTask(0, file)
+	if err != nil || task.Size == 0 {
+		return nil, NOT_FOUND_FILES
+	}
+
+	if len(md5sum) > 0 && strings.ToLower(md5sum) != task.M5Sum {
+		return nil, NOT_TRANSFER_FILE_MD5SUM
+	}
+
+	task.Priority = priority
+	task.HostNames = append(task.HostNames, hostNames...)
+
+	obj.Send(task)
+
+	return task, nil
+}
+
+// 受理文件 只校验文件存在 md5由SendPending在后台计算
+func (obj *JzRsync) PrepareFile(hostNames []string, file string, priority int) (*JzTask, error) {
+	task, err := NewFileTask(0, file)
+	if err != nil || task.Size == 0 {
+		return nil, NOT_FOUND_FILES
+	}
+
+	task.Id = GlobalData.Tasks.NextId()
+	task.Priority = priority
+	task.HostNames = append(task.HostNames, hostNames...)
+	task.SetState(TASK_PENDING)
+	GlobalData.Tasks.Track(task)
+
+	return task, nil
+}
+
+// 在后台逐个计算md5后提交 避免大批量文件阻塞请求
+func (obj *JzRsync) SendPending(tasks []*JzTask) {
+	if len(tasks) == 0 {
+		return
+	}
+
+	go func() {
+		for _, task := range tasks {
+			if task.Aborted() {
+				obj.cancelTask(task)
+				continue
+			}
+
+			if err := task.Checksum(); err != nil {
+				task.SetState(TASK_NOTFOUND)
+				GlobalData.Tasks.Finish(task)
+				continue
+			}
+
+			obj.Send(task)
+		}
+	}()
+}
+
+// 定时任务到期
+// 提交失败时记录为已结束的任务 以便按定时任务id查询结果
+func (obj *JzRsync) sendScheduled(t *JzScheduledTask) {
+	task, err := obj.SendFile(t.Targets, t.File, t.Md5, t.Priority)
+	if err != nil {
+		JzLogger.Printf("send scheduled task %s file %s failed %v", t.Id, t.File, err)
+
+		task = &JzTask{
+			Id:        GlobalData.Tasks.NextId(),
+			Name:      t.File,
+			HostNames: t.Targets,
+			Priority:  t.Priority,
+			completed: make(chan bool),
+		}
+
+		if err == NOT_TRANSFER_FILE_MD5SUM {
+			task.SetState(TASK_FAILED)
+		} else {
+			task.SetState(TASK_NOTFOUND)
+		}
+
+		GlobalData.Tasks.Track(task)
+		GlobalData.Tasks.Finish(task)
+		GlobalData.Tasks.Alias(t.Id, task.Key())
+		return
+	}
+
+	GlobalData.Tasks.Alias(t.Id, task.Key())
+
+	JzLogger.Printf("send scheduled task %s file %s as task %s", t.Id, t.File, task.Key())
+}
+
+func (obj *JzRsync) Scheduler() *JzTimerWheel {
+	return obj.scheduler
+}
+
+// 取消任务 队列中的任务直接移除 同步中的任务在当前目标结束后中止 定时任务直接删除 计算md5中的任务不再提交
+func (obj *JzRsync) Cancel(key string) bool {
+	//已到期的定时任务取消实际提交的任务
+	if strings.HasPrefix(key, "sched:") {
+		if obj.scheduler.Remove(key) {
+			return true
+		}
+		key = GlobalData.Tasks.Resolve(key)
+	}
+
+	if task := obj.queue.Remove(key); task != nil {
+		obj.cancelTask(task)
+		return true
+	}
+
+	if task := obj.removeHeld(key); task != nil {
+		obj.cancelTask(task)
+		return true
+	}
+
+	task := GlobalData.Tasks.Get(key)
+	if task == nil {
+		return false
+	}
+
+	state := task.CurrentState()
+	if state != TASK_PENDING && state != TASK_QUEUED && state != TASK_RUNNING {
+		return false
+	}
+
+	task.Abort()
+	return true
+}
+
+// 清空队列及挂起的任务 返回取消的任务数
+func (obj *JzRsync) Flush() int {
+	obj.poolLock.Lock()
+	tasks := obj.held
+	obj.held = nil
+	obj.poolLock.Unlock()
+
+	tasks = append(tasks, obj.queue.Flush()...)
+	for _, task := range tasks {
+		obj.cancelTask(task)
+	}
+
+	return len(tasks)
+}
+
+func (obj *JzRsync) cancelTask(task *JzTask) {
+	JzLogger.Printf("task %s cancelled", task.Key())
+	task.SetState(TASK_CANCELLED)
+	task.Cancel(499)
+	GlobalData.TaskMap.Delete(task.Key())
+	GlobalData.Tasks.Finish(task)
+}
+
 func (obj *JzRsync) Stop() {
 	JzLogger.Print("send Stopped signal")
 	obj.intervalToStopped <- true
@@ -295,56 +764,137 @@ func (obj *JzRsync) Stop() {
 	<-obj.stopped
 	<-obj.stopped
 
-	for _, ts := range obj.allTargetServer {
-		ts.Stop()
+	obj.scheduler.Stop()
+
+	obj.Pool().Stop()
+
+	if obj.leader != nil {
+		obj.leader.Stop()
+	}
+
+	if GlobalData.Auditor != nil {
+		GlobalData.Auditor.Close()
 	}
 
-	close(obj.transferChannel)
+	for _, source := range obj.Sources() {
+		source.Close()
+	}
 
 	JzLogger.Print("rsync stopped")
 }
 
+// 每次最多拉取到队列中有batch个任务 队列消耗过半后再继续拉取
 func (obj *JzRsync) pullTasks() {
-	tasks, err := JzDaoInstance().GetTasks()
-	if err != nil {
-		JzLogger.Print(err)
+	atomic.StoreInt64(&obj.lastPoll, time.Now().Unix())
+
+	limit := RsyncConfig().Batch - obj.queue.Len()
+	if limit <= 0 {
+		JzLogger.Printf("queue is full with %d tasks skip pull", obj.queue.Len())
+		atomic.StoreInt32(&obj.more, 1)
 		return
 	}
 
-	if len(tasks) > 0 {
+	for _, source := range obj.Sources() {
+		tasks, err := source.GetTasks(limit)
+		if err != nil {
+			JzLogger.Printf("pull tasks from %s failed %v", source.Name(), err)
+			continue
+		}
+
 		for _, t := range tasks {
-			obj.queue <- t
+			obj.Send(t)
+		}
+
+		limit -= len(tasks)
+		if limit <= 0 {
+			atomic.StoreInt32(&obj.more, 1)
+			break
 		}
 	}
 }
 
-func (obj *JzRsync) Run(newTask chan bool) {
-	if jzRsyncConfig.Interval > 0 {
-		go func() {
-			interval := time.NewTicker(time.Second * time.Duration(jzRsyncConfig.Interval))
-			defer interval.Stop()
+func (obj *JzRsync) Sources() []TaskSource {
+	obj.sourceLock.RLock()
+	defer obj.sourceLock.RUnlock()
 
-		F:
-			for {
-				select {
-				case <-obj.intervalToStopped:
-					JzLogger.Print("catch intervalStopped signal")
-					break F
-				case <-interval.C:
-					JzLogger.Print("catch pulltasks time event signal")
-					obj.pullTasks()
-				case <-newTask:
-					JzLogger.Print("catch pulltasks redis event signal")
-					obj.pullTasks()
-				}
+	return append([]TaskSource{}, obj.sources...)
+}
+
+func (obj *JzRsync) Source(name string) TaskSource {
+	for _, source := range obj.Sources() {
+		if source.Name() == name {
+			return source
+		}
+	}
+
+	return nil
+}
+
+func (obj *JzRsync) Leader() *JzLeader {
+	return obj.leader
+}
+
+// 已有未处理的拉取信号时忽略 不阻塞调用方
+func (obj *JzRsync) Pull() {
+	select {
+	case obj.newTask <- true:
+	default:
+	}
+}
+
+func (obj *JzRsync) Run() {
+
+	for _, source := range obj.Sources() {
+		if watcher, ok := source.(TaskWatcher); ok {
+			watcher.Watch(obj)
+		}
+	}
+
+	if obj.leader != nil {
+		obj.leader.Start()
+	}
+
+	obj.scheduler.Start()
+
+	go func() {
+		//interval为0时只响应拉取信号 重新加载配置时可修改
+		var interval *time.Ticker
+		var tick <-chan time.Time
+		reset := func(seconds int) {
+			if interval != nil {
+				interval.Stop()
+				interval, tick = nil, nil
 			}
 
-			obj.stopped <- true
-			JzLogger.Print("interval exit")
-		}()
-	} else {
+			if seconds > 0 {
+				interval = time.NewTicker(time.Second * time.Duration(seconds))
+				tick = interval.C
+			}
+		}
+		reset(RsyncConfig().Interval)
+		defer reset(0)
+
+	F:
+		for {
+			select {
+			case <-obj.intervalToStopped:
+				JzLogger.Print("catch intervalStopped signal")
+				break F
+			case seconds := <-obj.intervalChanged:
+				JzLogger.Printf("change pulltasks interval to %d", seconds)
+				reset(seconds)
+			case <-tick:
+				JzLogger.Print("catch pulltasks time event signal")
+				obj.pullTasks()
+			case <-obj.newTask:
+				JzLogger.Print("catch pulltasks redis event signal")
+				obj.pullTasks()
+			}
+		}
+
 		obj.stopped <- true
-	}
+		JzLogger.Print("interval exit")
+	}()
 
 E:
 	for {
@@ -352,9 +902,24 @@ E:
 		case <-obj.taskToStopped:
 			JzLogger.Print("catch taskToStopped signal")
 			break E
-		case task := <-obj.queue:
-			targetServer := <-obj.transferChannel
-			go Transfer(obj, targetServer, task)
+		case <-obj.queue.Ready():
+			//取得空闲传输通道后再出队 保证出队的是当时优先级最高的任务
+			pool, targetServer, ok := obj.acquire()
+			if !ok {
+				JzLogger.Print("catch taskToStopped signal")
+				break E
+			}
+
+			task := obj.queue.Pop()
+			if task == nil {
+				pool.Release(targetServer)
+				continue
+			}
+
+			if obj.queue.Len() <= RsyncConfig().Batch/2 && atomic.CompareAndSwapInt32(&obj.more, 1, 0) {
+				obj.Pull()
+			}
+			go Transfer(obj, pool, targetServer, task)
 		}
 	}
 
@@ -362,12 +927,23 @@ E:
 	JzLogger.Print("rsync exit")
 }
 
-func Transfer(obj *JzRsync, targetServer []*JzRsyncTarget, task *JzTask) {
+func Transfer(obj *JzRsync, pool *JzTransferPool, targetServer []*JzRsyncTarget, task *JzTask) {
 	startTime := time.Now()
 	JzLogger.Print("get task from queue", task)
+	task.SetState(TASK_RUNNING)
+	task.ExpectFinishedNum = len(targetServer)
 	n := 0
+	aborted := false
+	paused := make([]string, 0)
+T:
 	for _, hn := range task.HostNames {
 		for _, ts := range targetServer {
+			if task.Aborted() {
+				aborted = true
+				JzLogger.Printf("task id %d aborted", task.Id)
+				break T
+			}
+
 			JzLogger.Printf("task id %d-%s will rsync for %s[%s][%s]", task.Id, hn, ts.Name, ts.Target.Name, ts.Target.Address)
 			if hn != "*" && InStringArray(hn, ts.Target.Group) == false {
 				n += 1
@@ -375,19 +951,53 @@ func Transfer(obj *JzRsync, targetServer []*JzRsyncTarget, task *JzTask) {
 				continue
 			}
 
-			ok, err := ts.Rsync(task, task.RsyncMaxNum)
+			if InStringArray(ts.Target.Name, task.DoneTargets) {
+				n += 1
+				JzLogger.Printf("task id %d-%s rsync already done for %s[%s][%s]", task.Id, hn, ts.Name, ts.Target.Name, ts.Target.Address)
+				continue
+			}
+
+			if !obj.begin(ts.Target.Name) {
+				JzLogger.Printf("task id %d-%s rsync paused for %s[%s][%s]", task.Id, hn, ts.Name, ts.Target.Name, ts.Target.Address)
+				if !InStringArray(ts.Target.Name, paused) {
+					paused = append(paused, ts.Target.Name)
+				}
+				continue
+			}
+
+			ok, attempts, bytes, err := ts.Rsync(task, task.RsyncMaxNum)
+			obj.end(ts.Target.Name)
+			task.Report(ts.Target.Name, ok, attempts, bytes, err)
+			atomic.AddInt64(&obj.bytes, bytes)
 			if !ok {
+				atomic.AddInt64(&obj.failures, 1)
 				JzLogger.Print(err)
 				continue
 			}
 
 			JzLogger.Printf("task id %d-%s rsync success for %s[%s][%s]", task.Id, hn, ts.Name, ts.Target.Name, ts.Target.Address)
+			atomic.AddInt64(&obj.files, 1)
 
 			n += 1
 		}
 	}
-	task.Done(n)
+	//已同步成功的目标记为完成 挂起至暂停的目标恢复
+	if !aborted && len(paused) > 0 {
+		task.DoneTargets = append(task.DoneTargets, task.succeededTargets()...)
+		pool.Release(targetServer)
+		obj.hold(task, paused)
+		return
+	}
+
+	if aborted {
+		task.SetState(TASK_CANCELLED)
+		task.Cancel(499)
+	} else {
+		task.Done(n)
+	}
 	JzLogger.Printf("transfer queue task %v done cost time %s", task, time.Since(startTime).String())
-	GlobalData.TaskMap.Delete(task.Id)
-	obj.transferChannel <- targetServer
+	GlobalData.TaskMap.Delete(task.Key())
+	GlobalData.Tasks.Finish(task)
+	PublishTaskEvent(task)
+	pool.Release(targetServer)
 }
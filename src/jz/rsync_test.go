@@ -0,0 +1,296 @@
+package jz
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 受理时只校验文件存在 md5在后台计算后进入队列
+func TestJzRsyncSendPending(t *testing.T) {
+	dir := setupTestConfig(t)
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.jpg"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rsync := &JzRsync{queue: NewJzTaskQueue(0)}
+
+	if _, err := rsync.PrepareFile([]string{"A"}, "lost.jpg", 0); err != NOT_FOUND_FILES {
+		t.Fatalf("expect not found, got %v", err)
+	}
+
+	tasks := make([]*JzTask, 0)
+	for i := 0; i < 3; i++ {
+		task, err := rsync.PrepareFile([]string{"A"}, "a.jpg", i)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if task.CurrentState() != TASK_PENDING || len(task.M5Sum) > 0 || GlobalData.Tasks.Get(task.Key()) != task {
+			t.Fatalf("expect tracked pending task without md5, got %v", task.Status())
+		}
+		tasks = append(tasks, task)
+	}
+
+	if !rsync.Cancel(tasks[1].Key()) {
+		t.Fatal("expect pending task cancelled")
+	}
+
+	rsync.SendPending(tasks)
+
+	if !tasks[1].Wait(time.Second * 5) {
+		t.Fatal("expect cancelled pending task finished")
+	}
+
+	deadline := time.Now().Add(time.Second * 5)
+	for rsync.queue.Len() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	if rsync.queue.Len() != 2 {
+		t.Fatalf("expect 2 queued tasks, got %d", rsync.queue.Len())
+	}
+
+	for _, i := range []int{0, 2} {
+		if tasks[i].CurrentState() != TASK_QUEUED || tasks[i].M5Sum != "5d41402abc4b2a76b9719d911017c592" {
+			t.Errorf("expect queued task with md5, got %v", tasks[i].Status())
+		}
+	}
+
+	if tasks[1].CurrentState() != TASK_CANCELLED {
+		t.Errorf("expect cancelled task, got %s", tasks[1].CurrentState())
+	}
+}
+
+// 暂停目标的任务在其他目标完成后挂起 恢复后重新入队
+func TestJzRsyncHoldPaused(t *testing.T) {
+	dir := setupTestConfig(t)
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.jpg"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rsync := &JzRsync{queue: NewJzTaskQueue(0), paused: make(map[string]bool)}
+
+	newTask := func() *JzTask {
+		task, err := NewFileTask(GlobalData.Tasks.NextId(), "a.jpg")
+		if err != nil {
+			t.Fatal(err)
+		}
+		task.Report("B", true, 1, 5, nil)
+		task.Report("C", false, 3, 0, errors.New("timeout"))
+		return task
+	}
+
+	rsync.Pause("A", true)
+
+	held := newTask()
+	rsync.hold(held, []string{"A"})
+	if held.CurrentState() != TASK_PAUSED || rsync.Held() != 1 || rsync.queue.Len() != 0 {
+		t.Fatalf("expect held task, got %s held %d queued %d", held.CurrentState(), rsync.Held(), rsync.queue.Len())
+	}
+
+	cancelled := newTask()
+	rsync.hold(cancelled, []string{"A"})
+	if !rsync.Cancel(cancelled.Key()) || cancelled.CurrentState() != TASK_CANCELLED || rsync.Held() != 1 {
+		t.Fatalf("expect held task cancelled, got %s held %d", cancelled.CurrentState(), rsync.Held())
+	}
+
+	rsync.Pause("A", false)
+	if held.CurrentState() != TASK_QUEUED || rsync.Held() != 0 || rsync.queue.Len() != 1 {
+		t.Fatalf("expect requeued task, got %s held %d queued %d", held.CurrentState(), rsync.Held(), rsync.queue.Len())
+	}
+	GlobalData.TaskMap.Delete(rsync.queue.Pop().Key())
+
+	//挂起前目标已恢复时直接入队
+	resumed := newTask()
+	rsync.hold(resumed, []string{"A"})
+	if resumed.CurrentState() != TASK_QUEUED || rsync.Held() != 0 || rsync.queue.Len() != 1 {
+		t.Fatalf("expect queued task, got %s held %d queued %d", resumed.CurrentState(), rsync.Held(), rsync.queue.Len())
+	}
+	GlobalData.TaskMap.Delete(rsync.queue.Pop().Key())
+}
+
+// 暂停后不再开始新的同步 同步中的任务结束后目标空闲
+func TestJzRsyncIdle(t *testing.T) {
+	rsync := &JzRsync{paused: make(map[string]bool), syncing: make(map[string]int), idle: make(map[string][]chan bool)}
+
+	closed := func(c <-chan bool) bool {
+		select {
+		case <-c:
+			return true
+		case <-time.After(time.Millisecond * 50):
+			return false
+		}
+	}
+
+	if !closed(rsync.Idle("A")) {
+		t.Fatal("expect idle target")
+	}
+
+	if !rsync.begin("A") || !rsync.begin("A") {
+		t.Fatal("expect sync began")
+	}
+
+	rsync.Pause("A", true)
+	if rsync.begin("A") {
+		t.Fatal("expect paused target not began")
+	}
+
+	idle := rsync.Idle("A")
+	rsync.end("A")
+	if closed(idle) {
+		t.Fatal("expect target still syncing")
+	}
+
+	rsync.end("A")
+	if !closed(idle) || len(rsync.syncing) != 0 || len(rsync.idle) != 0 {
+		t.Fatalf("expect idle target, got syncing %v", rsync.syncing)
+	}
+}
+
+// 拉取信号合并 不阻塞也不遗留goroutine
+func TestJzRsyncPull(t *testing.T) {
+	rsync := &JzRsync{newTask: make(chan bool, 1)}
+
+	done := make(chan bool)
+	go func() {
+		rsync.Pull()
+		rsync.Pull()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expect pull not blocked")
+	}
+
+	if len(rsync.newTask) != 1 {
+		t.Fatalf("expect 1 pending signal, got %d", len(rsync.newTask))
+	}
+}
+
+func TestJzTaskSucceededTargets(t *testing.T) {
+	task := &JzTask{DoneTargets: []string{"A"}}
+	task.Report("A", true, 1, 5, nil)
+	task.Report("B", true, 1, 5, nil)
+	task.Report("C", false, 3, 0, errors.New("timeout"))
+
+	if targets := task.succeededTargets(); fmt.Sprint(targets) != "[B]" {
+		t.Fatalf("expect [B], got %v", targets)
+	}
+}
+
+// 主动关闭传输池不发布目标状态事件
+func TestJzTransferPoolCloseSilently(t *testing.T) {
+	publisher := setupTestPublisher(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(conn net.Conn) {
+				defer conn.Close()
+				buf := make([]byte, 64)
+				for {
+					if _, err := conn.Read(buf); err != nil {
+						return
+					}
+					conn.Write([]byte("+PONG\r\n"))
+				}
+			}(conn)
+		}
+	}()
+
+	server := JzTargetServer{Name: "close-silently", Address: ln.Addr().String()}
+	pool := NewJzTransferPool([]JzTargetServer{server})
+
+	//首次ping在启动5秒后
+	deadline := time.Now().Add(time.Second * 10)
+	for time.Now().Before(deadline) {
+		up := 0
+		for _, ts := range pool.conns {
+			if atomic.LoadInt32(&ts.connected) == CONNECTION_UP {
+				up++
+			}
+		}
+		if up == len(pool.conns) {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	jzTargetStates.Lock()
+	n := jzTargetStates.connected[server.Name]
+	jzTargetStates.Unlock()
+	if n != len(pool.conns) {
+		t.Fatalf("expect %d connections up, got %d", len(pool.conns), n)
+	}
+
+	pool.Retire()
+	<-pool.closed
+
+	if events := publisher.take(); len(events) != 0 {
+		t.Fatalf("expect no events, got %v", events)
+	}
+
+	jzTargetStates.Lock()
+	n = jzTargetStates.connected[server.Name]
+	jzTargetStates.Unlock()
+	if n != 0 {
+		t.Fatalf("expect released connections, got %d", n)
+	}
+}
+
+// 到期提交后按定时任务id查询实际的任务 提交失败时记录为已结束的任务
+func TestJzRsyncSendScheduled(t *testing.T) {
+	dir := setupTestConfig(t)
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.jpg"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rsync := &JzRsync{queue: NewJzTaskQueue(0)}
+
+	cases := []struct {
+		id     string
+		file   string
+		md5    string
+		expect string
+	}{
+		{"sched:test-1", "a.jpg", "", TASK_QUEUED},
+		{"sched:test-2", "a.jpg", "00000000000000000000000000000000", TASK_FAILED},
+		{"sched:test-3", "lost.jpg", "", TASK_NOTFOUND},
+	}
+
+	for _, c := range cases {
+		rsync.sendScheduled(&JzScheduledTask{Id: c.id, File: c.file, Md5: c.md5, Targets: []string{"A"}})
+
+		task := GlobalData.Tasks.Get(c.id)
+		if task == nil || task.CurrentState() != c.expect {
+			t.Errorf("%s: expect %s task, got %v", c.id, c.expect, task)
+			continue
+		}
+
+		if key := GlobalData.Tasks.Resolve(c.id); key != task.Key() {
+			t.Errorf("%s: resolve %s, expect %s", c.id, key, task.Key())
+		}
+	}
+
+	for rsync.queue.Len() > 0 {
+		GlobalData.TaskMap.Delete(rsync.queue.Pop().Key())
+	}
+}
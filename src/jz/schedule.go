@@ -0,0 +1,297 @@
+package jz
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 定时同步的文件 到期后按set提交
+type JzScheduledTask struct {
+	Id       string   `json:"id"`
+	File     string   `json:"file"`
+	Targets  []string `json:"targets"`
+	Md5      string   `json:"md5"`
+	Priority int      `json:"priority"`
+	At       int64    `json:"at"`
+}
+
+// 保存的文件 seq为已分配的最大id 重启后不重复分配
+type jzWheelFile struct {
+	Seq   int                `json:"seq"`
+	Tasks []*JzScheduledTask `json:"tasks"`
+}
+
+type jzWheelItem struct {
+	task *JzScheduledTask
+	slot int
+}
+
+// 秒级时间轮 按到期时间取模放入槽 每秒按墙钟处理上次之后经过的槽 到期与否比较At
+// 每次变更后写入文件 重启后恢复 到期的任务提交完成后才从文件中删除
+type JzTimerWheel struct {
+	sync.Mutex
+	slots    []map[string]*jzWheelItem
+	items    map[string]*jzWheelItem
+	firing   map[string]*JzScheduledTask
+	fireWait sync.WaitGroup
+	last     int64
+	seq      int
+	file     string
+	fire     func(t *JzScheduledTask)
+	stopped  chan bool
+	exited   chan bool
+}
+
+func NewJzTimerWheel(size int, file string, fire func(t *JzScheduledTask)) *JzTimerWheel {
+	obj := &JzTimerWheel{
+		slots:   make([]map[string]*jzWheelItem, size),
+		items:   make(map[string]*jzWheelItem),
+		firing:  make(map[string]*JzScheduledTask),
+		last:    time.Now().Unix(),
+		file:    file,
+		fire:    fire,
+		stopped: make(chan bool),
+		exited:  make(chan bool),
+	}
+
+	for i := range obj.slots {
+		obj.slots[i] = make(map[string]*jzWheelItem)
+	}
+
+	return obj
+}
+
+// 恢复文件中的定时任务 已过期的在下一秒提交
+func (obj *JzTimerWheel) Load() error {
+	data, err := ioutil.ReadFile(obj.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var f jzWheelFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return err
+	}
+
+	obj.Lock()
+	defer obj.Unlock()
+
+	obj.seq = f.Seq
+	tasks := f.Tasks
+	for _, t := range tasks {
+		if n, err := strconv.Atoi(strings.TrimPrefix(t.Id, "sched:")); err == nil && n > obj.seq {
+			obj.seq = n
+		}
+		obj.add(t)
+	}
+
+	JzLogger.Printf("load %d scheduled tasks from %s", len(tasks), obj.file)
+
+	return nil
+}
+
+func (obj *JzTimerWheel) Add(t *JzScheduledTask) string {
+	obj.Lock()
+	defer obj.Unlock()
+
+	obj.seq++
+	t.Id = fmt.Sprintf("sched:%d", obj.seq)
+	obj.add(t)
+	obj.save()
+
+	return t.Id
+}
+
+// 已过期的放入下一次处理的槽
+func (obj *JzTimerWheel) add(t *JzScheduledTask) {
+	at := t.At
+	if at <= obj.last {
+		at = obj.last + 1
+	}
+
+	item := &jzWheelItem{
+		task: t,
+		slot: int(at % int64(len(obj.slots))),
+	}
+
+	obj.slots[item.slot][t.Id] = item
+	obj.items[t.Id] = item
+}
+
+func (obj *JzTimerWheel) Remove(id string) bool {
+	obj.Lock()
+	defer obj.Unlock()
+
+	item, ok := obj.items[id]
+	if !ok {
+		return false
+	}
+
+	delete(obj.items, id)
+	delete(obj.slots[item.slot], id)
+	obj.save()
+
+	return true
+}
+
+// 按到期时间排序
+func (obj *JzTimerWheel) List() []*JzScheduledTask {
+	obj.Lock()
+	result := make([]*JzScheduledTask, 0, len(obj.items))
+	for _, item := range obj.items {
+		result = append(result, item.task)
+	}
+	obj.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].At != result[j].At {
+			return result[i].At < result[j].At
+		}
+		return result[i].Id < result[j].Id
+	})
+
+	return result
+}
+
+// 未到期或到期后尚未提交完成
+func (obj *JzTimerWheel) Has(id string) bool {
+	obj.Lock()
+	defer obj.Unlock()
+
+	_, ok := obj.items[id]
+	return ok || obj.firing[id] != nil
+}
+
+// 未到期的为scheduled 到期后提交完成前为pending 不存在时返回nil
+func (obj *JzTimerWheel) Status(id string) *JzTaskStatus {
+	obj.Lock()
+	defer obj.Unlock()
+
+	t, state := obj.firing[id], TASK_PENDING
+	if item, ok := obj.items[id]; ok {
+		t, state = item.task, TASK_SCHEDULED
+	}
+
+	if t == nil {
+		return nil
+	}
+
+	return &JzTaskStatus{
+		Id:       t.Id,
+		File:     AuditPath(t.File),
+		Md5:      t.Md5,
+		Targets:  t.Targets,
+		Priority: t.Priority,
+		State:    state,
+		Results:  make([]*JzTargetResult, 0),
+	}
+}
+
+func (obj *JzTimerWheel) Len() int {
+	obj.Lock()
+	defer obj.Unlock()
+
+	return len(obj.items)
+}
+
+// 包含到期后尚未提交完成的任务 崩溃重启后重新提交
+func (obj *JzTimerWheel) save() {
+	tasks := make([]*JzScheduledTask, 0, len(obj.items)+len(obj.firing))
+	for _, item := range obj.items {
+		tasks = append(tasks, item.task)
+	}
+	for _, t := range obj.firing {
+		tasks = append(tasks, t)
+	}
+
+	data, _ := json.Marshal(&jzWheelFile{Seq: obj.seq, Tasks: tasks})
+	if err := WriteFileAtomic(obj.file, data); err != nil {
+		JzLogger.Printf("save scheduled tasks to %s failed %v", obj.file, err)
+	}
+}
+
+// 处理上次之后到now的槽 ticker延迟或系统时间跳变时不会漏掉或提前提交 返回到期的任务
+func (obj *JzTimerWheel) tick(now int64) []*JzScheduledTask {
+	obj.Lock()
+	defer obj.Unlock()
+
+	due := make([]*JzScheduledTask, 0)
+	if now <= obj.last {
+		return due
+	}
+
+	from := obj.last + 1
+	if now-from >= int64(len(obj.slots)) {
+		from = now - int64(len(obj.slots)) + 1
+	}
+	obj.last = now
+
+	for at := from; at <= now; at++ {
+		slot := obj.slots[at%int64(len(obj.slots))]
+		for id, item := range slot {
+			if item.task.At > now {
+				continue
+			}
+
+			due = append(due, item.task)
+			delete(slot, id)
+			delete(obj.items, id)
+			obj.firing[id] = item.task
+		}
+	}
+
+	return due
+}
+
+// 每个任务提交后再从文件中删除
+func (obj *JzTimerWheel) fireAll(due []*JzScheduledTask) {
+	for _, t := range due {
+		obj.fire(t)
+
+		obj.Lock()
+		delete(obj.firing, t.Id)
+		obj.save()
+		obj.Unlock()
+	}
+}
+
+func (obj *JzTimerWheel) Start() {
+	go func() {
+		interval := time.NewTicker(time.Second)
+		defer interval.Stop()
+
+		for {
+			select {
+			case <-obj.stopped:
+				close(obj.exited)
+				return
+			case <-interval.C:
+				//提交时计算md5 不阻塞时间轮
+				if due := obj.tick(time.Now().Unix()); len(due) > 0 {
+					obj.fireWait.Add(1)
+					go func() {
+						defer obj.fireWait.Done()
+						obj.fireAll(due)
+					}()
+				}
+			}
+		}
+	}()
+}
+
+// 等待提交中的任务完成
+func (obj *JzTimerWheel) Stop() {
+	close(obj.stopped)
+	<-obj.exited
+	obj.fireWait.Wait()
+}
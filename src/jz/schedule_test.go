@@ -0,0 +1,113 @@
+package jz
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestJzTimerWheelTick(t *testing.T) {
+	dir := setupTestConfig(t)
+	base := int64(1000000)
+
+	cases := []struct {
+		name   string
+		ats    []int64
+		ticks  []int64
+		expect []int
+	}{
+		{"due at second", []int64{3}, []int64{1, 2, 3}, []int{0, 0, 1}},
+		{"beyond one round", []int64{25}, []int64{10, 20, 24, 25}, []int{0, 0, 0, 1}},
+		{"expired on add", []int64{-5}, []int64{1}, []int{1}},
+		{"ticker late", []int64{3, 4, 9}, []int64{7, 9}, []int{2, 1}},
+		{"clock jump past wheel", []int64{3, 15, 200}, []int64{100, 200}, []int{2, 1}},
+		{"clock backwards", []int64{3}, []int64{-10, 2, 3}, []int{0, 0, 1}},
+		{"same slot next round", []int64{2, 12}, []int64{2, 11, 12}, []int{1, 0, 1}},
+	}
+
+	for i, c := range cases {
+		wheel := NewJzTimerWheel(10, filepath.Join(dir, fmt.Sprintf("%d.json", i)), nil)
+		wheel.last = base
+
+		for _, at := range c.ats {
+			wheel.Add(&JzScheduledTask{File: "a.jpg", At: base + at})
+		}
+
+		for j, tick := range c.ticks {
+			due := wheel.tick(base + tick)
+			if len(due) != c.expect[j] {
+				t.Errorf("%s: tick %d due %d, expect %d", c.name, tick, len(due), c.expect[j])
+			}
+
+			for _, task := range due {
+				if task.At > base+tick {
+					t.Errorf("%s: tick %d fired task at %d", c.name, tick, task.At-base)
+				}
+			}
+		}
+
+		if wheel.Len() != 0 {
+			t.Errorf("%s: expect all fired, %d left", c.name, wheel.Len())
+		}
+	}
+}
+
+// 提交完成前仍可按id查询且保留在文件中 重启后不重复分配已使用的id
+func TestJzTimerWheelFire(t *testing.T) {
+	dir := setupTestConfig(t)
+	file := filepath.Join(dir, "schedule.json")
+
+	load := func() *JzTimerWheel {
+		wheel := NewJzTimerWheel(10, file, nil)
+		if err := wheel.Load(); err != nil {
+			t.Fatal(err)
+		}
+		return wheel
+	}
+
+	fired := make([]string, 0)
+	wheel := NewJzTimerWheel(10, file, func(task *JzScheduledTask) {
+		fired = append(fired, task.Id)
+	})
+
+	id := wheel.Add(&JzScheduledTask{File: "a.jpg", At: wheel.last + 2})
+	removed := wheel.Add(&JzScheduledTask{File: "b.jpg", At: wheel.last + 2})
+	later := wheel.Add(&JzScheduledTask{File: "c.jpg", At: wheel.last + 30})
+
+	if !wheel.Remove(removed) || wheel.Remove(removed) {
+		t.Fatal("expect removed once")
+	}
+
+	if status := wheel.Status(id); status == nil || status.State != TASK_SCHEDULED || status.File != "a.jpg" {
+		t.Fatalf("expect scheduled status, got %v", status)
+	}
+
+	due := wheel.tick(wheel.last + 2)
+	if len(due) != 1 || due[0].Id != id || !wheel.Has(id) {
+		t.Fatalf("expect %s firing, got %v", id, due)
+	}
+
+	if status := wheel.Status(id); status == nil || status.State != TASK_PENDING {
+		t.Fatalf("expect pending status, got %v", status)
+	}
+
+	//提交完成前崩溃 重启后重新提交
+	if loaded := load(); !loaded.Has(id) || !loaded.Has(later) || loaded.Len() != 2 {
+		t.Fatalf("expect %s and %s loaded, got %d tasks", id, later, loaded.Len())
+	}
+
+	wheel.fireAll(due)
+	if fmt.Sprint(fired) != fmt.Sprint([]string{id}) || wheel.Has(id) || wheel.Status(id) != nil {
+		t.Fatalf("expect %s fired, got %v", id, fired)
+	}
+
+	if loaded := load(); !loaded.Has(later) || loaded.Len() != 1 {
+		t.Fatalf("expect %s loaded, got %d tasks", later, loaded.Len())
+	}
+
+	wheel.Remove(later)
+
+	if next := load().Add(&JzScheduledTask{File: "d.jpg", At: wheel.last + 2}); next != "sched:4" {
+		t.Fatalf("expect sched:4 after restart, got %s", next)
+	}
+}
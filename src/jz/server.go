@@ -1,13 +1,18 @@
 package jz
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"os/signal"
 	"sync"
 	"syscall"
 	"github.com/jonnywang/go-kits/redis"
 	"strings"
+	"strconv"
+	"time"
+	"path"
 )
 
 var (
@@ -15,32 +20,42 @@ var (
 	ERR_TARGET_HOST = errors.New("error target host")
 	NOT_FOUND_FILES = errors.New("not found rsync files")
 	NOT_TRANSFER_FILE_MD5SUM = errors.New("error transfer file md5sum")
+	ERR_TASK_SOURCE = errors.New("error task source")
+	NOT_FOUND_TASKS = errors.New("not found tasks")
+	NOT_ENABLE_LEADER = errors.New("leader election not enabled")
+	NOT_ENABLE_AUDIT = errors.New("audit not enabled")
+	ERR_WAIT_TIMEOUT = errors.New("wait task timeout")
+	NOT_FOUND_TARGET = errors.New("not found target server")
+	ERR_TARGET_EXISTS = errors.New("target server already exists")
+	ERR_LAST_TARGET = errors.New("can not remove the last target server")
 )
 
 const (
-	VERSION = "0.0.5"
+	VERSION = "0.0.6"
 )
 
 type JzRsyncRedisHandle struct {
 	redis.RedisHandler
 	sync.Mutex
 	rsync *JzRsync
-	pullSig chan bool
 }
 
 func (obj *JzRsyncRedisHandle) Init() error {
 	obj.Lock()
 	defer obj.Unlock()
 
-	obj.pullSig = make(chan bool)
-
 	obj.Initiation(nil)
 
+	GlobalData.Publisher = obj
+
 	obj.rsync = &JzRsync{}
-	obj.rsync.Init()
+	err := obj.rsync.Init()
+	if err != nil {
+		return err
+	}
 
 	go func() {
-		obj.rsync.Run(obj.pullSig)
+		obj.rsync.Run()
 	}()
 
 	return nil
@@ -55,87 +70,698 @@ func (obj *JzRsyncRedisHandle) Version() (string, error) {
 	return VERSION, nil
 }
 
+func (obj *JzRsyncRedisHandle) Info() (string, error) {
+	return obj.rsync.Info(), nil
+}
+
 func (obj *JzRsyncRedisHandle) Sync() (error) {
-	go func() {
-		obj.pullSig <- true
-	}()
+	obj.rsync.Pull()
 	return nil
 }
 
-func (obj *JzRsyncRedisHandle) Setex(hostName, file, md5sum string) (error) {
+func (obj *JzRsyncRedisHandle) Setex(hostName, file, md5sum string) (string, error) {
 	return obj.Set(hostName, file, "EX", md5sum)
 }
 
-func (obj *JzRsyncRedisHandle) Set(hostName, file, action, md5sum string) (error) {
-	if len(hostName) == 0 || len(file) == 0 {
+// set server_name file [EX md5sum] [PRIORITY n] [AT unix_ts | DELAY seconds] 返回任务id 定时同步时返回定时任务id
+func (obj *JzRsyncRedisHandle) Set(hostName, file string, options ...string) (string, error) {
+	if len(hostName) == 0 || len(file) == 0 || len(options)%2 != 0 {
+		return "", ERR_PARAMS
+	}
+
+	md5sum := ""
+	priority := 0
+	var at int64
+
+	for i := 0; i < len(options); i += 2 {
+		switch strings.ToLower(options[i]) {
+		case "ex":
+			md5sum = options[i+1]
+			if len(md5sum) != 32 {
+				return "", ERR_PARAMS
+			}
+		case "priority":
+			n, err := strconv.Atoi(options[i+1])
+			if err != nil {
+				return "", ERR_PARAMS
+			}
+			priority = n
+		case "at":
+			n, err := strconv.ParseInt(options[i+1], 10, 64)
+			if err != nil || n <= 0 || at > 0 {
+				return "", ERR_PARAMS
+			}
+			at = n
+		case "delay":
+			n, err := strconv.ParseInt(options[i+1], 10, 64)
+			if err != nil || n < 0 || at > 0 {
+				return "", ERR_PARAMS
+			}
+			at = time.Now().Unix() + n
+		default:
+			return "", ERR_PARAMS
+		}
+	}
+
+	hostNames, err := obj.targetHostNames(hostName)
+	if err != nil {
+		return "", err
+	}
+
+	//定时同步 到期时再校验md5
+	if at > 0 {
+		if IsGlobPattern(file) {
+			return "", ERR_PARAMS
+		}
+
+		n, err := GetFileSize(path.Join(RsyncConfig().Repertory, file))
+		if err != nil || n == 0 {
+			return "", NOT_FOUND_FILES
+		}
+
+		return obj.rsync.Scheduler().Add(&JzScheduledTask{
+			File:     file,
+			Targets:  hostNames,
+			Md5:      md5sum,
+			Priority: priority,
+			At:       at,
+		}), nil
+	}
+
+	//含通配符时展开为批量任务 返回批次id及每个文件的受理结果
+	if IsGlobPattern(file) {
+		if len(md5sum) > 0 {
+			return "", ERR_PARAMS
+		}
+
+		results, err := obj.sendFiles(hostNames, []string{file}, priority)
+		if err != nil {
+			return "", err
+		}
+
+		data, err := json.Marshal(map[string]interface{}{
+			"batch": GlobalData.Tasks.AddBatch(results),
+			"files": results,
+		})
+
+		return string(data), err
+	}
+
+	task, err := obj.rsync.SendFile(hostNames, file, md5sum, priority)
+	if err != nil {
+		return "", err
+	}
+
+	return task.Key(), nil
+}
+
+// setmulti server_name file1 file2 ... 文件可含通配符 返回批次id及每个文件的受理结果
+func (obj *JzRsyncRedisHandle) Setmulti(hostName string, files ...string) ([][]byte, error) {
+	if len(hostName) == 0 || len(files) == 0 {
+		return nil, ERR_PARAMS
+	}
+
+	hostNames, err := obj.targetHostNames(hostName)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := obj.sendFiles(hostNames, files, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([][]byte, 0, len(results)+1)
+	result = append(result, []byte(GlobalData.Tasks.AddBatch(results)))
+	for _, r := range results {
+		data, _ := json.Marshal(r)
+		result = append(result, data)
+	}
+
+	return result, nil
+}
+
+// batch batch_id 批次中每个文件的受理结果及任务状态
+func (obj *JzRsyncRedisHandle) Batch(id string) ([][]byte, error) {
+	files := GlobalData.Tasks.Batch(id)
+	if files == nil {
+		return nil, NOT_FOUND_TASKS
+	}
+
+	result := make([][]byte, 0, len(files))
+	for _, r := range files {
+		data, _ := json.Marshal(r)
+		result = append(result, data)
+	}
+
+	return result, nil
+}
+
+func (obj *JzRsyncRedisHandle) targetHostNames(hostName string) ([]string, error) {
+	hostNames := strings.Split(strings.ToUpper(hostName), ",")
+	if false == InStringArray("*", hostNames) && false == HasIntersection(hostNames, obj.rsync.HostNames()) {
+		return nil, ERR_TARGET_HOST
+	}
+
+	return hostNames, nil
+}
+
+// 逐个受理文件 通配符在repertory下展开 没有匹配到任何文件时返回错误 md5在后台计算后提交
+func (obj *JzRsyncRedisHandle) sendFiles(hostNames []string, files []string, priority int) ([]*JzBatchFile, error) {
+	//先展开全部通配符 出错时不受理任何文件
+	matches := make([]string, 0, len(files))
+	for _, file := range files {
+		if !IsGlobPattern(file) {
+			matches = append(matches, file)
+			continue
+		}
+
+		found, err := GlobFiles(RsyncConfig().Repertory, file)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, found...)
+	}
+
+	if len(matches) == 0 {
+		return nil, NOT_FOUND_FILES
+	}
+
+	result := make([]*JzBatchFile, 0, len(matches))
+	tasks := make([]*JzTask, 0)
+	for _, f := range matches {
+		r := &JzBatchFile{File: f}
+		task, err := obj.rsync.PrepareFile(hostNames, f, priority)
+		if err != nil {
+			r.Error = err.Error()
+		} else {
+			r.Id = task.Key()
+			r.State = TASK_PENDING
+			tasks = append(tasks, task)
+		}
+		result = append(result, r)
+	}
+
+	obj.rsync.SendPending(tasks)
+
+	return result, nil
+}
+
+// get task_id 任务状态及各目标同步结果 来源任务的id为source:id
+func (obj *JzRsyncRedisHandle) Get(key string) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ERR_PARAMS
+	}
+
+	//未到期或正在提交的定时任务 已提交的按实际的任务查询
+	if status := obj.rsync.Scheduler().Status(key); status != nil {
+		return json.Marshal(status)
+	}
+	key = GlobalData.Tasks.Resolve(key)
+
+	var status *JzTaskStatus
+	if task := GlobalData.Tasks.Get(key); task != nil {
+		status = task.Status()
+	} else {
+		i := strings.LastIndex(key, ":")
+		if i <= 0 {
+			return nil, NOT_FOUND_TASKS
+		}
+
+		id, err := strconv.Atoi(key[i+1:])
+		if err != nil {
+			return nil, ERR_PARAMS
+		}
+
+		inspector, ok := obj.rsync.Source(key[:i]).(TaskInspector)
+		if !ok {
+			return nil, ERR_TASK_SOURCE
+		}
+
+		status, err = inspector.InspectTask(id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(status)
+}
+
+// waittask task_id timeout 阻塞等待任务结束 timeout为秒 0为一直等待
+func (obj *JzRsyncRedisHandle) Waittask(key, timeout string) ([]byte, error) {
+	seconds, err := strconv.Atoi(timeout)
+	if len(key) == 0 || err != nil || seconds < 0 {
+		return nil, ERR_PARAMS
+	}
+
+	deadline := time.Now().Add(time.Second * time.Duration(seconds))
+
+	//未到期的定时任务先等待提交
+	for strings.HasPrefix(key, "sched:") && obj.rsync.Scheduler().Has(key) {
+		if seconds > 0 && time.Now().After(deadline) {
+			return nil, ERR_WAIT_TIMEOUT
+		}
+		time.Sleep(time.Millisecond * time.Duration(100))
+	}
+
+	task := GlobalData.Tasks.Get(key)
+	if task == nil {
+		//已不在内存中的任务直接返回记录的状态
+		return obj.Get(GlobalData.Tasks.Resolve(key))
+	}
+
+	wait := time.Duration(0)
+	if seconds > 0 {
+		wait = time.Until(deadline)
+		if wait <= 0 {
+			return nil, ERR_WAIT_TIMEOUT
+		}
+	}
+
+	if !task.Wait(wait) {
+		return nil, ERR_WAIT_TIMEOUT
+	}
+
+	return json.Marshal(task.Status())
+}
+
+// 目标列表及状态 每个目标为json
+func (obj *JzRsyncRedisHandle) Targets() ([][]byte, error) {
+	result := make([][]byte, 0)
+	for _, target := range obj.rsync.Pool().Targets() {
+		data, _ := json.Marshal(map[string]interface{}{
+			"name":    target.Name,
+			"address": target.Address,
+			"groups":  target.Group,
+			"paused":  obj.rsync.Paused(target.Name),
+		})
+		result = append(result, data)
+	}
+
+	return result, nil
+}
+
+// target add name address groups | remove name | pause name | resume name | groups name groups | drain name [timeout]
+func (obj *JzRsyncRedisHandle) Target(action, name string, args ...string) (error) {
+	if len(name) == 0 {
 		return ERR_PARAMS
 	}
 
-	if len(action) > 0 {
-		if strings.ToLower(action) != "ex" {
+	findTarget := func(servers []JzTargetServer) int {
+		for i := range servers {
+			if servers[i].Name == name {
+				return i
+			}
+		}
+		return -1
+	}
+
+	removeTarget := func(servers []JzTargetServer) ([]JzTargetServer, error) {
+		i := findTarget(servers)
+		if i < 0 {
+			return nil, NOT_FOUND_TARGET
+		}
+		return append(servers[:i], servers[i+1:]...), nil
+	}
+
+	switch strings.ToLower(action) {
+	case "add":
+		if len(args) != 2 || len(args[0]) == 0 || len(args[1]) == 0 {
+			return ERR_PARAMS
+		}
+
+		_, err := obj.rsync.UpdateTargets(func(servers []JzTargetServer) ([]JzTargetServer, error) {
+			if findTarget(servers) >= 0 {
+				return nil, ERR_TARGET_EXISTS
+			}
+			return append(servers, JzTargetServer{
+				Name:    name,
+				Address: args[0],
+				Group:   strings.Split(strings.ToUpper(args[1]), ","),
+			}), nil
+		})
+		return err
+	case "remove":
+		if len(args) != 0 {
 			return ERR_PARAMS
 		}
 
-		if len(md5sum) != 32 {
+		_, err := obj.rsync.UpdateTargets(removeTarget)
+		if err == nil {
+			obj.rsync.Pause(name, false)
+		}
+		return err
+	case "groups":
+		if len(args) != 1 || len(args[0]) == 0 {
+			return ERR_PARAMS
+		}
+
+		_, err := obj.rsync.UpdateTargets(func(servers []JzTargetServer) ([]JzTargetServer, error) {
+			i := findTarget(servers)
+			if i < 0 {
+				return nil, NOT_FOUND_TARGET
+			}
+			servers[i].Group = strings.Split(strings.ToUpper(args[0]), ",")
+			return servers, nil
+		})
+		return err
+	case "pause", "resume":
+		if len(args) != 0 {
+			return ERR_PARAMS
+		}
+
+		if obj.rsync.Pool().Target(name) == nil {
+			return NOT_FOUND_TARGET
+		}
+
+		obj.rsync.Pause(name, strings.ToLower(action) == "pause")
+		return nil
+	case "drain":
+		//暂停目标 等待使用该目标的同步结束后移除 timeout为秒 0为一直等待 超时时恢复原暂停状态且不移除
+		seconds := 0
+		if len(args) > 1 {
 			return ERR_PARAMS
 		}
+
+		if len(args) == 1 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 0 {
+				return ERR_PARAMS
+			}
+			seconds = n
+		}
+
+		if obj.rsync.Pool().Target(name) == nil {
+			return NOT_FOUND_TARGET
+		}
+
+		paused := obj.rsync.Paused(name)
+		obj.rsync.Pause(name, true)
+
+		var timeout <-chan time.Time
+		if seconds > 0 {
+			timeout = time.After(time.Second * time.Duration(seconds))
+		}
+
+		select {
+		case <-obj.rsync.Idle(name):
+		case <-timeout:
+			obj.rsync.Pause(name, paused)
+			return ERR_WAIT_TIMEOUT
+		}
+
+		//移除后挂起的任务重新入队 只同步到剩余的目标 移除失败时恢复原暂停状态
+		if _, err := obj.rsync.UpdateTargets(removeTarget); err != nil {
+			obj.rsync.Pause(name, paused)
+			return err
+		}
+		obj.rsync.Pause(name, false)
+		return nil
 	}
 
-	hostNames := strings.Split(strings.ToUpper(hostName), ",")
-	if false == InStringArray("*", hostNames) && false == HasIntersection(hostNames, obj.rsync.AllTargetHostNames) {
-		return ERR_TARGET_HOST
+	return ERR_PARAMS
+}
+
+// config reload 重新加载配置文件 返回变更说明 | config get pattern 查看运行中的配置
+func (obj *JzRsyncRedisHandle) Config(action string, args ...string) ([][]byte, error) {
+	result := make([][]byte, 0)
+
+	switch strings.ToLower(action) {
+	case "reload":
+		if len(args) != 0 {
+			return nil, ERR_PARAMS
+		}
+
+		changes, err := obj.rsync.Reload()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, change := range changes {
+			result = append(result, []byte(change))
+		}
+	case "get":
+		if len(args) != 1 {
+			return nil, ERR_PARAMS
+		}
+
+		for _, v := range obj.rsync.ConfigValues(args[0]) {
+			result = append(result, []byte(v))
+		}
+	default:
+		return nil, ERR_PARAMS
+	}
+
+	return result, nil
+}
+
+// scheduled [offset] [count] 按到期时间列出定时任务
+func (obj *JzRsyncRedisHandle) Scheduled(options ...string) ([][]byte, error) {
+	offset, count, err := pageOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	tasks := obj.rsync.Scheduler().List()
+
+	result := make([][]byte, 0)
+	for i := offset; i < len(tasks) && len(result) < count; i++ {
+		data, _ := json.Marshal(tasks[i])
+		result = append(result, data)
+	}
+
+	return result, nil
+}
+
+// 解析分页参数 [offset] [count]
+func pageOptions(options []string) (int, int, error) {
+	offset, count := 0, 20
+	if len(options) > 2 {
+		return 0, 0, ERR_PARAMS
+	}
+
+	if len(options) > 0 {
+		n, err := strconv.Atoi(options[0])
+		if err != nil || n < 0 {
+			return 0, 0, ERR_PARAMS
+		}
+		offset = n
+	}
+
+	if len(options) > 1 {
+		n, err := strconv.Atoi(options[1])
+		if err != nil || n <= 0 {
+			return 0, 0, ERR_PARAMS
+		}
+		count = n
+	}
+
+	return offset, count, nil
+}
+
+// queue [offset] [count] 按出队顺序列出队列中的任务
+func (obj *JzRsyncRedisHandle) Queue(options ...string) ([][]byte, error) {
+	offset, count, err := pageOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([][]byte, 0)
+	for _, t := range obj.rsync.queue.List(offset, count) {
+		data, _ := json.Marshal(t.Status())
+		result = append(result, data)
+	}
+
+	return result, nil
+}
+
+// inflight [offset] [count] 列出同步中的任务
+func (obj *JzRsyncRedisHandle) Inflight(options ...string) ([][]byte, error) {
+	offset, count, err := pageOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	running := GlobalData.Tasks.Running()
+
+	result := make([][]byte, 0)
+	for i := offset; i < len(running) && len(result) < count; i++ {
+		data, _ := json.Marshal(running[i])
+		result = append(result, data)
+	}
+
+	return result, nil
+}
+
+func (obj *JzRsyncRedisHandle) Cancel(key string) (error) {
+	if len(key) == 0 {
+		return ERR_PARAMS
 	}
 
-	task, err := AssembleTask(0, file)
-	if err != nil || task.Size == 0 {
-		return NOT_FOUND_FILES
+	if !obj.rsync.Cancel(key) {
+		return NOT_FOUND_TASKS
 	}
 
-	if len(md5sum) > 0 && strings.ToLower(md5sum) != task.M5Sum {
-		return NOT_TRANSFER_FILE_MD5SUM
+	return nil
+}
+
+// 清空队列 返回取消的任务数
+func (obj *JzRsyncRedisHandle) Flush() (int, error) {
+	return obj.rsync.Flush(), nil
+}
+
+func (obj *JzRsyncRedisHandle) Retry(sourceName, id string) (error) {
+	taskId, err := strconv.Atoi(id)
+	if len(sourceName) == 0 || err != nil {
+		return ERR_PARAMS
 	}
 
-	task.HostNames = append(task.HostNames, hostNames...)
+	retrier, ok := obj.rsync.Source(sourceName).(TaskRetrier)
+	if !ok {
+		return ERR_TASK_SOURCE
+	}
 
-	obj.rsync.Send(task)
+	tasks, err := retrier.GetTasksByIds([]int{taskId})
+	if err != nil {
+		return err
+	}
+
+	if len(tasks) == 0 {
+		return NOT_FOUND_TASKS
+	}
+
+	for _, t := range tasks {
+		obj.rsync.Send(t)
+	}
 
 	return nil
 }
 
+func (obj *JzRsyncRedisHandle) Leader() (string, error) {
+	leader := obj.rsync.Leader()
+	if leader == nil {
+		return "", NOT_ENABLE_LEADER
+	}
+
+	owner, lease, err := leader.Current()
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s %d", owner, lease), nil
+}
+
+// history file [limit] 最近的同步记录 每条为json
+func (obj *JzRsyncRedisHandle) History(file string, options ...string) ([][]byte, error) {
+	if len(file) == 0 || len(options) > 1 {
+		return nil, ERR_PARAMS
+	}
+
+	if GlobalData.Auditor == nil {
+		return nil, NOT_ENABLE_AUDIT
+	}
+
+	limit := 10
+	if len(options) == 1 {
+		n, err := strconv.Atoi(options[0])
+		if err != nil || n <= 0 {
+			return nil, ERR_PARAMS
+		}
+		limit = n
+	}
+
+	records, err := GlobalData.Auditor.History(file, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([][]byte, 0, len(records))
+	for _, r := range records {
+		data, _ := json.Marshal(r)
+		result = append(result, data)
+	}
+
+	return result, nil
+}
+
 func Run() {
-	redis.Logger.Print(jzRsyncConfig)
+	redis.Logger.Print(RsyncConfig())
 
 	jzRsyncRedisHandle := &JzRsyncRedisHandle{}
 
-	defer func() {
-		jzRsyncRedisHandle.Shutdown()
-	}()
-
 	err := jzRsyncRedisHandle.Init()
 	if err != nil {
 		JzLogger.Print(err)
 		return
 	}
 
-	server, err := redis.NewServer(jzRsyncConfig.Address, jzRsyncRedisHandle)
+	defer func() {
+		jzRsyncRedisHandle.Shutdown()
+	}()
+
+	hups := make(chan os.Signal, 1)
+	signal.Notify(hups, syscall.SIGHUP)
+
+	go func() {
+		for range hups {
+			JzLogger.Print("catch SIGHUP signal reload config")
+			if _, err := jzRsyncRedisHandle.rsync.Reload(); err != nil {
+				JzLogger.Printf("reload config failed %v", err)
+			}
+		}
+	}()
+
+	sigs := make(chan os.Signal)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+
+	server, err := redis.NewServer(RsyncConfig().Address, jzRsyncRedisHandle)
 	if err != nil {
 		JzLogger.Print(err)
 		return
 	}
 
-	sigs := make(chan os.Signal)
-	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+	//启用认证或socket时自行监听 连接在redis服务读取命令前校验AUTH及命令权限
+	if RsyncConfig().Auth.Enabled() || len(RsyncConfig().Socket) > 0 {
+		listeners := make([]*JzAuthListener, 0)
+		defer func() {
+			for _, listener := range listeners {
+				listener.Close()
+			}
+		}()
+
+		for _, l := range [][]string{{"tcp", RsyncConfig().Address}, {"unix", RsyncConfig().Socket}} {
+			if len(l[1]) == 0 {
+				continue
+			}
+
+			listener, err := NewJzAuthListener(l[0], l[1])
+			if err != nil {
+				JzLogger.Print(err)
+				return
+			}
+			listeners = append(listeners, listener)
+
+			go func() {
+				if err := server.Serve(listener); err != nil {
+					JzLogger.Print(err)
+				}
+			}()
+		}
+
+		<-sigs
+		server.Stop(10)
+		return
+	}
 
 	go func() {
 		<-sigs
 		server.Stop(10)
 	}()
 
-	redis.Logger.Printf("server run at %s", jzRsyncConfig.Address)
+	redis.Logger.Printf("server run at %s", RsyncConfig().Address)
 
 	err = server.Start()
 	if err != nil {
 		JzLogger.Print(err)
 	}
 }
-
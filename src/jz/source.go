@@ -0,0 +1,106 @@
+package jz
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// 任务来源 每次最多拉取limit个待同步任务并回写同步结果
+type TaskSource interface {
+	Name() string
+	GetTasks(limit int) ([]*JzTask, error)
+	UpdateTask(id int, status int) (int64, error)
+	Close()
+}
+
+// 可主动通知有新任务的任务来源 由rsync启动后调用
+type TaskWatcher interface {
+	Watch(rsync *JzRsync)
+}
+
+// 可记录每个目标同步结果的任务来源
+type TargetReporter interface {
+	ReportTarget(id int, result *JzTargetResult) error
+}
+
+// 可按id重新拉取任务的任务来源 已成功的目标不再重发
+type TaskRetrier interface {
+	GetTasksByIds(ids []int) ([]*JzTask, error)
+}
+
+// 基于JzDao的任务来源
+type DaoTaskSource interface {
+	Dao() *JzDao
+}
+
+type TaskSourceCreator func(config *JzSourceConfig) (TaskSource, error)
+
+var taskSourceCreators = make(map[string]TaskSourceCreator)
+
+func RegisterTaskSource(sourceType string, creator TaskSourceCreator) {
+	taskSourceCreators[strings.ToLower(sourceType)] = creator
+}
+
+func NewTaskSource(config *JzSourceConfig) (TaskSource, error) {
+	creator, ok := taskSourceCreators[strings.ToLower(config.Type)]
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("unknown task source type %s for %s", config.Type, config.Name))
+	}
+
+	return creator(config)
+}
+
+// 基于数据库的来源比较底层的JzDao 任务中记录的可能是包装前的来源
+func SameSource(a TaskSource, b TaskSource) bool {
+	da, ok := a.(DaoTaskSource)
+	if !ok {
+		return a == b
+	}
+
+	db, ok := b.(DaoTaskSource)
+	if !ok {
+		return false
+	}
+
+	return da.Dao() == db.Dao()
+}
+
+func TaskKey(sourceName string, id int) string {
+	return fmt.Sprintf("%s:%d", sourceName, id)
+}
+
+// 校验来源中的一条记录并组装为任务 返回错误时应回写404
+func AssembleSourceTask(source TaskSource, id int, uri string, md5sum string, dest string) (*JzTask, error) {
+	if len(uri) == 0 {
+		JzLogger.Printf("pull empty task with %s", TaskKey(source.Name(), id))
+		return nil, NOT_FOUND_FILES
+	}
+
+	if len(dest) == 0 {
+		JzLogger.Printf("pull unknown target server task with %s", TaskKey(source.Name(), id))
+		return nil, ERR_TARGET_HOST
+	}
+
+	task, err := AssembleTask(id, uri)
+	if err != nil {
+		JzLogger.Printf("assemble task file %s failed %v", path.Join(RsyncConfig().Repertory, uri), err)
+		return nil, err
+	}
+
+	if task.Size == 0 {
+		JzLogger.Printf("get task file %s size failed", path.Join(RsyncConfig().Repertory, uri))
+		return nil, NOT_FOUND_FILES
+	}
+
+	if len(md5sum) > 0 && strings.ToLower(md5sum) != task.M5Sum {
+		JzLogger.Printf("get task file %s md5sum failed %s %s", path.Join(RsyncConfig().Repertory, uri), strings.ToLower(md5sum), task.M5Sum)
+		return nil, NOT_TRANSFER_FILE_MD5SUM
+	}
+
+	task.Source = source
+	task.HostNames = append(task.HostNames, strings.Split(strings.ToUpper(dest), ",")...)
+
+	return task, nil
+}
@@ -0,0 +1,137 @@
+package jz
+
+import (
+	"errors"
+	"fmt"
+	_ "github.com/mattn/go-sqlite3"
+	"strings"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS {table} (
+  {id} INTEGER PRIMARY KEY AUTOINCREMENT,
+  {uri} VARCHAR(1024) DEFAULT NULL,
+  {md5} VARCHAR(50) DEFAULT NULL,
+  {dest} VARCHAR(10) DEFAULT NULL,
+  {status} INTEGER DEFAULT 0,
+  {at} INTEGER NOT NULL DEFAULT 0,
+  {attempts} INTEGER NOT NULL DEFAULT 0,
+  {owner} VARCHAR(64) DEFAULT NULL,
+  {lease} INTEGER NOT NULL DEFAULT 0,{priority}
+  time INTEGER DEFAULT NULL
+);
+CREATE INDEX IF NOT EXISTS {table}_{uri} ON {table} ({uri});
+CREATE INDEX IF NOT EXISTS {table}_{status} ON {table} ({status});
+`
+
+const sqliteTargetsSchema = `
+CREATE TABLE IF NOT EXISTS {targets} (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  {t_file_id} INTEGER NOT NULL,
+  {t_target} VARCHAR(64) NOT NULL,
+  {t_status} INTEGER NOT NULL DEFAULT 0,
+  {t_attempts} INTEGER NOT NULL DEFAULT 0,
+  {t_error} VARCHAR(1024) DEFAULT NULL,
+  {t_finished} INTEGER NOT NULL DEFAULT 0
+);
+CREATE UNIQUE INDEX IF NOT EXISTS {targets}_file_target ON {targets} ({t_file_id}, {t_target});
+`
+
+const sqliteLeaderSchema = `
+CREATE TABLE IF NOT EXISTS {leader} (
+  name VARCHAR(64) PRIMARY KEY,
+  owner VARCHAR(64) NOT NULL DEFAULT '',
+  lease INTEGER NOT NULL DEFAULT 0
+);
+`
+
+const sqliteAuditSchema = `
+CREATE TABLE IF NOT EXISTS {audit} (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  task VARCHAR(128) NOT NULL,
+  path VARCHAR(1024) NOT NULL,
+  target VARCHAR(64) NOT NULL,
+  address VARCHAR(64) NOT NULL,
+  bytes INTEGER NOT NULL DEFAULT 0,
+  duration INTEGER NOT NULL DEFAULT 0,
+  result VARCHAR(16) NOT NULL,
+  error VARCHAR(1024) DEFAULT NULL,
+  time INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS {audit}_path ON {audit} (path);
+`
+
+func init() {
+	RegisterTaskSource("sqlite", NewJzSqliteDao)
+}
+
+func NewJzSqliteDao(config *JzSourceConfig) (TaskSource, error) {
+	if len(config.Path) == 0 {
+		return nil, errors.New(fmt.Sprintf("task source %s sqlite path is required", config.Name))
+	}
+
+	JzLogger.Printf("start open sqlite database %s", config.Path)
+
+	dao, err := openJzDao(config, "sqlite3", fmt.Sprintf("file:%s?_busy_timeout=5000", config.Path))
+	if err != nil {
+		return nil, err
+	}
+
+	//sqlite同一时间只允许一个写入
+	dao.db.SetMaxOpenConns(1)
+
+	columns := config.Table.Columns
+	schema := sqliteSchema
+	if len(config.Table.Targets) > 0 {
+		schema += sqliteTargetsSchema
+	}
+
+	if RsyncConfig().Leader.Source == config.Name {
+		schema += sqliteLeaderSchema
+	}
+
+	if RsyncConfig().Audit.Type == "db" && RsyncConfig().Audit.Source == config.Name {
+		schema += sqliteAuditSchema
+	}
+
+	priority := ""
+	if len(columns.Priority) > 0 {
+		priority = fmt.Sprintf("\n  %s INTEGER NOT NULL DEFAULT 0,", columns.Priority)
+	}
+
+	schema = strings.NewReplacer(
+		"{table}", config.Table.Name,
+		"{targets}", config.Table.Targets,
+		"{leader}", RsyncConfig().Leader.Table,
+		"{audit}", RsyncConfig().Audit.Table,
+		"{id}", columns.Id,
+		"{uri}", columns.Uri,
+		"{md5}", columns.Md5,
+		"{dest}", columns.Dest,
+		"{status}", columns.Status,
+		"{at}", columns.At,
+		"{attempts}", columns.Attempts,
+		"{owner}", columns.Owner,
+		"{lease}", columns.Lease,
+		"{priority}", priority,
+		"{t_file_id}", config.Table.TargetColumns.FileId,
+		"{t_target}", config.Table.TargetColumns.Target,
+		"{t_status}", config.Table.TargetColumns.Status,
+		"{t_attempts}", config.Table.TargetColumns.Attempts,
+		"{t_error}", config.Table.TargetColumns.Error,
+		"{t_finished}", config.Table.TargetColumns.Finished,
+	).Replace(schema)
+
+	_, err = dao.db.Exec(schema)
+	if err == nil {
+		err = dao.Setup()
+	}
+
+	if err != nil {
+		dao.Close()
+		JzLogger.Printf("create sqlite table %s failed %v", config.Table.Name, err)
+		return nil, err
+	}
+
+	return dao, nil
+}
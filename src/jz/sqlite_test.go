@@ -0,0 +1,445 @@
+package jz
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 以临时目录作为repertory的运行配置
+func setupTestConfig(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "jz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+
+	setRsyncConfig(&JzRsyncConfig{
+		Repertory: dir,
+		Instance:  "test",
+		Batch:     1000,
+		Fairness:  10,
+	})
+
+	return dir
+}
+
+func newTestSqliteDao(t *testing.T, dir string, name string) *JzDao {
+	config := &JzSourceConfig{Name: name, Type: "sqlite", Path: filepath.Join(dir, name+".db")}
+	config.Cursor = filepath.Join(dir, name+".cursor")
+	config.Table.SetDefaults()
+	config.Retry.SetDefaults()
+
+	source, err := NewJzSqliteDao(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		//阻塞时连接未归还 关闭会一直等待
+		if !t.Failed() {
+			source.Close()
+		}
+	})
+
+	return source.(*JzDao)
+}
+
+func (dao *JzDao) testStatus(t *testing.T, id int) int {
+	var status int
+	err := dao.db.QueryRow("select status from sync_files where id=?", id).Scan(&status)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return status
+}
+
+func TestSqliteGetTasksMissingFile(t *testing.T) {
+	dir := setupTestConfig(t)
+	dao := newTestSqliteDao(t, dir, "missing")
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.jpg"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rows := [][]string{
+		{"lost.jpg", "5d41402abc4b2a76b9719d911017c592"},
+		{"a.jpg", "5d41402abc4b2a76b9719d911017c592"},
+		{"a.jpg", "00000000000000000000000000000000"},
+	}
+	for _, r := range rows {
+		_, err := dao.db.Exec("insert into sync_files (uri,md5,dest) values (?,?,'A')", r[0], r[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type pulled struct {
+		tasks []*JzTask
+		err   error
+	}
+
+	done := make(chan pulled, 1)
+	go func() {
+		tasks, err := dao.GetTasks(10)
+		done <- pulled{tasks, err}
+	}()
+
+	var p pulled
+	select {
+	case p = <-done:
+	case <-time.After(time.Second * 10):
+		t.Fatal("GetTasks blocked while cancelling a missing file")
+	}
+
+	if p.err != nil {
+		t.Fatal(p.err)
+	}
+
+	if len(p.tasks) != 1 || p.tasks[0].Id != 2 {
+		t.Fatalf("expect only task 2, got %v", p.tasks)
+	}
+	GlobalData.TaskMap.Delete(p.tasks[0].Key())
+
+	for id, expect := range map[int]int{1: 404, 2: 0, 3: 404} {
+		if status := dao.testStatus(t, id); status != expect {
+			t.Errorf("task %d status %d, expect %d", id, status, expect)
+		}
+	}
+}
+
+func testInsertTask(t *testing.T, dao *JzDao, uri string, at int64) int {
+	result, err := dao.db.Exec("insert into sync_files (uri,md5,dest,at) values (?,'5d41402abc4b2a76b9719d911017c592','A',?)", uri, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, _ := result.LastInsertId()
+	return int(id)
+}
+
+func testTaskIds(tasks []*JzTask) []int {
+	ids := make([]int, 0)
+	for _, task := range tasks {
+		ids = append(ids, task.Id)
+		GlobalData.TaskMap.Delete(task.Key())
+	}
+
+	return ids
+}
+
+// 游标越过后到期的定时任务仍会被拉取
+func TestSqliteGetTasksScheduled(t *testing.T) {
+	dir := setupTestConfig(t)
+	dao := newTestSqliteDao(t, dir, "scheduled")
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.jpg"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	at := time.Now().Unix() + 3600
+	later := testInsertTask(t, dao, "a.jpg", at)
+	now := testInsertTask(t, dao, "a.jpg", 0)
+
+	tasks, err := dao.GetTasks(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ids := testTaskIds(tasks); len(ids) != 1 || ids[0] != now {
+		t.Fatalf("expect task %d, got %v", now, ids)
+	}
+
+	next, err := dao.NextScheduled([]int{later, now})
+	if err != nil || next != at {
+		t.Fatalf("expect next scheduled at %d, got %d %v", at, next, err)
+	}
+
+	if _, err := dao.db.Exec("update sync_files set at=? where id=?", time.Now().Unix()-1, later); err != nil {
+		t.Fatal(err)
+	}
+
+	tasks, err = dao.GetTasks(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ids := testTaskIds(tasks); len(ids) != 1 || ids[0] != later {
+		t.Fatalf("expect task %d, got %v", later, ids)
+	}
+}
+
+// targets表使用配置的字段名
+func TestSqliteReportTargetColumns(t *testing.T) {
+	dir := setupTestConfig(t)
+
+	config := &JzSourceConfig{Name: "columns", Type: "sqlite", Path: filepath.Join(dir, "columns.db")}
+	config.Cursor = filepath.Join(dir, "columns.cursor")
+	config.Table.Targets = "sync_targets"
+	config.Table.TargetColumns = JzTargetColumns{FileId: "task_id", Target: "server", Finished: "done_at"}
+	config.Table.SetDefaults()
+	config.Retry.SetDefaults()
+
+	source, err := NewJzSqliteDao(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+	dao := source.(*JzDao)
+
+	id := testInsertTask(t, dao, "a.jpg", 0)
+	results := []*JzTargetResult{
+		{Target: "A", Status: 500, Attempts: 1, Error: "timeout", Finished: 1},
+		{Target: "A", Status: 200, Attempts: 1, Finished: 2},
+		{Target: "B", Status: 500, Attempts: 1, Error: "refused", Finished: 3},
+	}
+	for _, result := range results {
+		if err := dao.ReportTarget(id, result); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done, err := dao.doneTargets(id)
+	if err != nil || len(done) != 1 || done[0] != "A" {
+		t.Fatalf("expect done target A, got %v %v", done, err)
+	}
+
+	status, err := dao.InspectTask(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(status.Results) != 2 || status.Results[0].Attempts != 2 || status.Results[1].Error != "refused" || status.Finished != 3 {
+		t.Errorf("error target results %+v", status.Results)
+	}
+}
+
+// 崩溃时未完成的任务在重启后重新拉取
+func TestSqliteCursorKeepsUnfinished(t *testing.T) {
+	dir := setupTestConfig(t)
+	dao := newTestSqliteDao(t, dir, "cursor")
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.jpg"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := []int{testInsertTask(t, dao, "a.jpg", 0), testInsertTask(t, dao, "a.jpg", 0), testInsertTask(t, dao, "a.jpg", 0)}
+
+	tasks, err := dao.GetTasks(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pulled := testTaskIds(tasks); len(pulled) != 3 {
+		t.Fatalf("expect 3 tasks, got %v", pulled)
+	}
+
+	for _, id := range []int{ids[0], ids[2]} {
+		if _, err := dao.UpdateTask(id, 200); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//模拟崩溃后重启 使用同一游标文件
+	restarted := newTestSqliteDao(t, dir, "cursor")
+	tasks, err = restarted.GetTasks(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pulled := testTaskIds(tasks); len(pulled) != 1 || pulled[0] != ids[1] {
+		t.Fatalf("expect unfinished task %d, got %v", ids[1], pulled)
+	}
+
+	if _, err := restarted.UpdateTask(ids[1], 200); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "cursor.cursor"))
+	if err != nil || string(data) != fmt.Sprint(ids[1]) {
+		t.Errorf("expect cursor %d, got %s %v", ids[1], data, err)
+	}
+}
+
+func TestSqliteClaim(t *testing.T) {
+	dir := setupTestConfig(t)
+	dao := newTestSqliteDao(t, dir, "claim")
+	dao.config.Lease = 60
+
+	id := testInsertTask(t, dao, "a.jpg", 0)
+	now := time.Now().Unix()
+
+	cases := []struct {
+		status int
+		lease  int64
+		expect bool
+	}{
+		{0, 0, true},
+		{500, 0, true},
+		{206, 0, true},
+		{102, now - 1, true},
+		{102, now + 60, false},
+		{200, 0, false},
+		{404, 0, false},
+		{410, 0, false},
+		{499, 0, false},
+	}
+
+	for _, c := range cases {
+		if _, err := dao.db.Exec("update sync_files set status=?,lease=?,owner='other' where id=?", c.status, c.lease, id); err != nil {
+			t.Fatal(err)
+		}
+
+		if ok := dao.claim(id); ok != c.expect {
+			t.Errorf("claim task with status %d lease %d = %v, expect %v", c.status, c.lease, ok, c.expect)
+		}
+	}
+}
+
+// binlog运行时仍逐页拉取积压任务 直到不足一页
+func TestSqliteGetTasksPagesBacklog(t *testing.T) {
+	dir := setupTestConfig(t)
+	dao := newTestSqliteDao(t, dir, "backlog")
+	dao.binlog = NewJzBinlog(dao, nil)
+	dao.binlog.setRunning(true)
+	close(dao.binlog.exited)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.jpg"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := make([]int, 0)
+	for i := 0; i < 5; i++ {
+		ids = append(ids, testInsertTask(t, dao, "a.jpg", 0))
+	}
+
+	//积压未拉取完时binlog通知的任务不推进游标
+	tasks, err := dao.GetPendingTasksByIds([]int{ids[4]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pulled := testTaskIds(tasks); len(pulled) != 1 || pulled[0] != ids[4] {
+		t.Fatalf("expect binlog task %d, got %v", ids[4], pulled)
+	}
+
+	for _, expect := range [][]int{{ids[0], ids[1]}, {ids[2], ids[3]}, {}} {
+		tasks, err := dao.GetTasks(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pulled := testTaskIds(tasks)
+		if fmt.Sprint(pulled) != fmt.Sprint(expect) {
+			t.Fatalf("expect page %v, got %v", expect, pulled)
+		}
+	}
+
+	if !dao.drained || len(dao.ahead) != 0 {
+		t.Errorf("expect drained backlog without ahead records, got %v %v", dao.drained, dao.ahead)
+	}
+
+	//追上后新记录由binlog通知 轮询不再按游标拉取
+	later := testInsertTask(t, dao, "a.jpg", 0)
+	tasks, err = dao.GetTasks(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pulled := testTaskIds(tasks); len(pulled) != 0 {
+		t.Fatalf("expect no polled tasks, got %v", pulled)
+	}
+
+	tasks, err = dao.GetPendingTasksByIds([]int{later})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pulled := testTaskIds(tasks); len(pulled) != 1 || dao.id != later {
+		t.Errorf("expect binlog task %d advance cursor, got %v cursor %d", later, pulled, dao.id)
+	}
+}
+
+// 重新加载创建的同名来源不重置本实例同步中的任务 启动时才恢复
+func TestSqliteSetupKeepsLeases(t *testing.T) {
+	dir := setupTestConfig(t)
+	dao := newTestSqliteDao(t, dir, "leases")
+	dao.config.Lease = 60
+
+	id := testInsertTask(t, dao, "a.jpg", 0)
+	if _, err := dao.db.Exec("update sync_files set status=102,lease=?,owner='test' where id=?", time.Now().Unix()+60, id); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := newTestSqliteDao(t, dir, "leases")
+	reloaded.config.Lease = 60
+	if status := reloaded.testStatus(t, id); status != 102 {
+		t.Fatalf("expect running task kept on reload, got %d", status)
+	}
+
+	if err := reloaded.recoverLeases(); err != nil {
+		t.Fatal(err)
+	}
+	if status := reloaded.testStatus(t, id); status != 500 {
+		t.Fatalf("expect running task recovered on start, got %d", status)
+	}
+}
+
+func TestSameSource(t *testing.T) {
+	dir := setupTestConfig(t)
+	dao := newTestSqliteDao(t, dir, "same")
+	other := newTestSqliteDao(t, dir, "other")
+	wrapped := &JzPostgresDao{JzDao: dao}
+
+	cases := []struct {
+		a      TaskSource
+		b      TaskSource
+		expect bool
+	}{
+		{dao, dao, true},
+		{dao, wrapped, true},
+		{wrapped, dao, true},
+		{dao, other, false},
+		{nil, dao, false},
+		{nil, nil, true},
+	}
+
+	for i, c := range cases {
+		if ok := SameSource(c.a, c.b); ok != c.expect {
+			t.Errorf("case %d: SameSource = %v, expect %v", i, ok, c.expect)
+		}
+	}
+}
+
+// 被替换的来源不再保存游标 避免覆盖新的同名来源
+func TestSqliteRetireStopsCursor(t *testing.T) {
+	dir := setupTestConfig(t)
+	dao := newTestSqliteDao(t, dir, "retire")
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.jpg"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := []int{testInsertTask(t, dao, "a.jpg", 0), testInsertTask(t, dao, "a.jpg", 0)}
+
+	tasks, err := dao.GetTasks(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testTaskIds(tasks)
+
+	cursor := filepath.Join(dir, "retire.cursor")
+	before, _ := ioutil.ReadFile(cursor)
+
+	dao.Retire()
+	for _, id := range ids {
+		if _, err := dao.UpdateTask(id, 200); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if after, _ := ioutil.ReadFile(cursor); string(after) != string(before) {
+		t.Fatalf("expect cursor %s kept after retire, got %s", before, after)
+	}
+}
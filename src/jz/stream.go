@@ -0,0 +1,402 @@
+package jz
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 以redis stream消费组作为任务来源 多个实例共享同一消费组 至少投递一次
+// Mutex只保护消息与任务的对应关系 redis请求由connLock串行 不阻塞其他任务回写
+type JzStreamSource struct {
+	sync.Mutex
+	name       string
+	address    string
+	password   string
+	database   string
+	stream     string
+	group      string
+	consumer   string
+	idle       int64
+	deliveries int64
+	dead       string
+	conn       *JzRespConn
+	connLock   sync.Mutex
+	seq        int
+	entries    map[int]string
+	ids        map[string]int
+	recovered  bool
+	lastId     string
+}
+
+func init() {
+	RegisterTaskSource("stream", NewJzStreamSource)
+}
+
+func NewJzStreamSource(config *JzSourceConfig) (TaskSource, error) {
+	if len(config.Address) == 0 || len(config.Stream) == 0 {
+		return nil, errors.New(fmt.Sprintf("task source %s stream address and stream is required", config.Name))
+	}
+
+	group := config.Group
+	if len(group) == 0 {
+		group = "jzRedisRsync"
+	}
+
+	//重启后沿用同一消费者 先处理上次退出前已读取但未确认的消息
+	consumer := config.Consumer
+	if len(consumer) == 0 {
+		consumer = RsyncConfig().Instance
+	}
+
+	idle := config.Idle
+	if idle <= 0 {
+		idle = 600
+	}
+
+	deliveries := config.Deliveries
+	if deliveries <= 0 {
+		deliveries = 10
+	}
+
+	dead := config.Dead
+	if len(dead) == 0 {
+		dead = config.Stream + ":dead"
+	}
+
+	return &JzStreamSource{
+		name:       config.Name,
+		address:    config.Address,
+		password:   config.Password,
+		database:   config.Database,
+		stream:     config.Stream,
+		group:      group,
+		consumer:   consumer,
+		idle:       int64(idle) * 1000,
+		deliveries: int64(deliveries),
+		dead:       dead,
+		entries:    make(map[int]string),
+		ids:        make(map[string]int),
+		lastId:     "0",
+	}, nil
+}
+
+func (obj *JzStreamSource) Name() string {
+	return obj.name
+}
+
+func (obj *JzStreamSource) do(args ...string) (interface{}, error) {
+	obj.connLock.Lock()
+	defer obj.connLock.Unlock()
+
+	if obj.conn == nil {
+		conn, err := DialResp(obj.address, obj.password, obj.database)
+		if err != nil {
+			JzLogger.Printf("connect stream %s server %s failed %v", obj.name, obj.address, err)
+			return nil, err
+		}
+
+		_, err = conn.Do("XGROUP", "CREATE", obj.stream, obj.group, "0", "MKSTREAM")
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			conn.Close()
+			JzLogger.Printf("create stream %s group %s failed %v", obj.stream, obj.group, err)
+			return nil, err
+		}
+
+		obj.conn = conn
+	}
+
+	reply, err := obj.conn.Do(args...)
+	if _, ok := err.(RespError); err != nil && !ok {
+		obj.conn.Close()
+		obj.conn = nil
+	}
+
+	return reply, err
+}
+
+func (obj *JzStreamSource) Close() {
+	obj.connLock.Lock()
+	defer obj.connLock.Unlock()
+
+	if obj.conn != nil {
+		obj.conn.Close()
+		obj.conn = nil
+	}
+
+	JzLogger.Printf("stream %s closed", obj.name)
+}
+
+// 只由拉取任务的goroutine调用 recovered及lastId不与其他方法共享
+func (obj *JzStreamSource) GetTasks(limit int) ([]*JzTask, error) {
+	entries := make([]interface{}, 0)
+
+	//上次退出前已读取但未确认的消息
+	if !obj.recovered {
+		reply, err := obj.do("XREADGROUP", "GROUP", obj.group, obj.consumer, "COUNT", strconv.Itoa(limit), "STREAMS", obj.stream, obj.lastId)
+		if err != nil {
+			return nil, err
+		}
+
+		pending := streamReadEntries(reply)
+		if len(pending) < limit {
+			obj.recovered = true
+		}
+
+		if len(pending) > 0 {
+			obj.lastId, _, _ = streamEntry(pending[len(pending)-1])
+		}
+
+		entries = append(entries, pending...)
+	}
+
+	claimed, err := obj.claim(limit)
+	if err != nil {
+		return nil, err
+	}
+	entries = append(entries, claimed...)
+
+	if len(entries) < limit {
+		reply, err := obj.do("XREADGROUP", "GROUP", obj.group, obj.consumer, "COUNT", strconv.Itoa(limit-len(entries)), "STREAMS", obj.stream, ">")
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, streamReadEntries(reply)...)
+	}
+
+	result := make([]*JzTask, 0)
+
+	for _, entry := range entries {
+		streamId, fields, ok := streamEntry(entry)
+		if !ok {
+			continue
+		}
+
+		id, ok := obj.track(streamId)
+		if !ok {
+			continue
+		}
+
+		task, err := AssembleSourceTask(obj, id, fields["uri"], fields["md5"], fields["dest"])
+		if err != nil {
+			obj.UpdateTask(id, 404)
+			continue
+		}
+
+		task.Priority, _ = strconv.Atoi(fields["priority"])
+
+		GlobalData.TaskMap.Store(task.Key(), true)
+
+		JzLogger.Printf("got task from stream %s entry %s %v", obj.name, streamId, task)
+
+		result = append(result, task)
+	}
+
+	JzLogger.Printf("pull %d tasks from %s", len(result), obj.name)
+
+	return result, nil
+}
+
+// 记录消息对应的任务id 消息已在同步中时返回false
+func (obj *JzStreamSource) track(streamId string) (int, bool) {
+	obj.Lock()
+	defer obj.Unlock()
+
+	if _, ok := obj.ids[streamId]; ok {
+		return 0, false
+	}
+
+	obj.seq++
+	obj.entries[obj.seq] = streamId
+	obj.ids[streamId] = obj.seq
+
+	return obj.seq, true
+}
+
+func (obj *JzStreamSource) tracking(streamId string) bool {
+	obj.Lock()
+	defer obj.Unlock()
+
+	_, ok := obj.ids[streamId]
+	return ok
+}
+
+// 分页遍历消费组中未确认的消息 认领其他消费者超时未确认的 最多limit条
+// 投递次数达到deliveries的消息写入dead后确认 不再重发
+func (obj *JzStreamSource) claim(limit int) ([]interface{}, error) {
+	count := limit
+	if count < 100 {
+		count = 100
+	}
+
+	args := []string{"XCLAIM", obj.stream, obj.group, obj.consumer, strconv.FormatInt(obj.idle, 10)}
+	start := "-"
+
+	for len(args)-5 < limit {
+		reply, err := obj.do("XPENDING", obj.stream, obj.group, start, "+", strconv.Itoa(count))
+		if err != nil {
+			return nil, err
+		}
+
+		pending, _ := reply.([]interface{})
+		for _, p := range pending {
+			item, ok := p.([]interface{})
+			if !ok || len(item) < 4 {
+				continue
+			}
+
+			streamId, _ := item[0].(string)
+			start = streamNextId(streamId)
+
+			idle, _ := item[2].(int64)
+			if idle < obj.idle {
+				continue
+			}
+
+			if obj.tracking(streamId) {
+				continue
+			}
+
+			deliveries, _ := item[3].(int64)
+			if deliveries >= obj.deliveries {
+				err := obj.bury(streamId, deliveries)
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+
+			args = append(args, streamId)
+			if len(args)-5 >= limit {
+				break
+			}
+		}
+
+		if len(pending) < count || len(start) == 0 {
+			break
+		}
+	}
+
+	if len(args) == 5 {
+		return nil, nil
+	}
+
+	reply, err := obj.do(args...)
+	if err != nil {
+		return nil, err
+	}
+
+	claimed, _ := reply.([]interface{})
+	JzLogger.Printf("claim %d stale entries from stream %s", len(claimed), obj.stream)
+
+	return claimed, nil
+}
+
+// 复制消息到dead后确认 先写入再确认 异常退出时最多重复写入
+func (obj *JzStreamSource) bury(streamId string, deliveries int64) error {
+	reply, err := obj.do("XRANGE", obj.stream, streamId, streamId)
+	if err != nil {
+		return err
+	}
+
+	entries, _ := reply.([]interface{})
+	if len(entries) > 0 {
+		_, fields, _ := streamEntry(entries[0])
+
+		args := []string{"XADD", obj.dead, "*", "entry", streamId, "deliveries", strconv.FormatInt(deliveries, 10)}
+		for k, v := range fields {
+			args = append(args, k, v)
+		}
+
+		_, err = obj.do(args...)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = obj.do("XACK", obj.stream, obj.group, streamId)
+	if err != nil {
+		return err
+	}
+
+	JzLogger.Printf("stream %s entry %s failed after %d deliveries, moved to %s", obj.stream, streamId, deliveries, obj.dead)
+	return nil
+}
+
+// 紧随其后的消息id 用于分页 格式错误时返回空
+func streamNextId(id string) string {
+	i := strings.Index(id, "-")
+	if i <= 0 {
+		return ""
+	}
+
+	seq, err := strconv.ParseUint(id[i+1:], 10, 64)
+	if err != nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%s-%d", id[:i], seq+1)
+}
+
+// 失败及部分成功的消息不确认 超过idle后被重新认领
+func (obj *JzStreamSource) UpdateTask(id int, status int) (int64, error) {
+	obj.Lock()
+	streamId, ok := obj.entries[id]
+	delete(obj.entries, id)
+	delete(obj.ids, streamId)
+	obj.Unlock()
+
+	if !ok {
+		return 0, errors.New(fmt.Sprintf("not found stream entry for task %d", id))
+	}
+
+	if status == 500 || status == 206 {
+		return 0, nil
+	}
+
+	reply, err := obj.do("XACK", obj.stream, obj.group, streamId)
+	if err != nil {
+		return 0, err
+	}
+
+	n, _ := reply.(int64)
+
+	return n, nil
+}
+
+func streamReadEntries(reply interface{}) []interface{} {
+	streams, _ := reply.([]interface{})
+	if len(streams) == 0 {
+		return nil
+	}
+
+	stream, _ := streams[0].([]interface{})
+	if len(stream) < 2 {
+		return nil
+	}
+
+	entries, _ := stream[1].([]interface{})
+
+	return entries
+}
+
+func streamEntry(entry interface{}) (string, map[string]string, bool) {
+	item, ok := entry.([]interface{})
+	if !ok || len(item) < 2 {
+		return "", nil, false
+	}
+
+	streamId, _ := item[0].(string)
+	values, _ := item[1].([]interface{})
+
+	fields := make(map[string]string)
+	for i := 0; i+1 < len(values); i += 2 {
+		k, _ := values[i].(string)
+		v, _ := values[i+1].(string)
+		fields[strings.ToLower(k)] = v
+	}
+
+	return streamId, fields, len(streamId) > 0
+}
@@ -0,0 +1,247 @@
+package jz
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newTestStreamSource(t *testing.T, m *miniredis.Miniredis, name string) *JzStreamSource {
+	source, err := NewJzStreamSource(&JzSourceConfig{
+		Name:    name,
+		Type:    "stream",
+		Address: m.Addr(),
+		Stream:  "sync_files",
+		Idle:    60,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(source.Close)
+
+	return source.(*JzStreamSource)
+}
+
+func testRespConn(t *testing.T, m *miniredis.Miniredis) *JzRespConn {
+	conn, err := DialResp(m.Addr(), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(conn.Close)
+
+	return conn
+}
+
+func testPendingCount(t *testing.T, conn *JzRespConn) int64 {
+	reply, err := conn.Do("XPENDING", "sync_files", "jzRedisRsync")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary, _ := reply.([]interface{})
+	if len(summary) == 0 {
+		t.Fatalf("error xpending reply %v", reply)
+	}
+
+	n, _ := summary[0].(int64)
+	return n
+}
+
+func forgetTasks(tasks []*JzTask) {
+	for _, task := range tasks {
+		GlobalData.TaskMap.Delete(task.Key())
+	}
+}
+
+func TestStreamSourceAck(t *testing.T) {
+	dir := setupTestConfig(t)
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.jpg"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := miniredis.RunT(t)
+	conn := testRespConn(t, m)
+	source := newTestStreamSource(t, m, "stream-ack")
+
+	entries := [][]string{
+		{"uri", "a.jpg", "md5", "5d41402abc4b2a76b9719d911017c592", "dest", "cdn", "priority", "5"},
+		{"uri", "lost.jpg", "dest", "cdn"},
+		{"uri", "a.jpg", "dest", "cdn"},
+		{"uri", "a.jpg", "dest", "cdn"},
+		{"uri", "a.jpg", "dest", "cdn"},
+	}
+	for _, fields := range entries {
+		if _, err := conn.Do(append([]string{"XADD", "sync_files", "*"}, fields...)...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tasks, err := source.GetTasks(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer forgetTasks(tasks)
+
+	if len(tasks) != 4 {
+		t.Fatalf("expect 4 tasks, got %d", len(tasks))
+	}
+
+	if tasks[0].Priority != 5 || tasks[0].HostNames[0] != "CDN" {
+		t.Errorf("error task %v", tasks[0])
+	}
+
+	//文件不存在的消息已确认
+	if n := testPendingCount(t, conn); n != 4 {
+		t.Fatalf("expect 4 pending entries, got %d", n)
+	}
+
+	for i, status := range []int{200, 500, 206} {
+		if _, err := source.UpdateTask(tasks[i].Id, status); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := testPendingCount(t, conn); n != 3 {
+		t.Errorf("expect failed, partial and unfinished entries stay pending, got %d pending", n)
+	}
+}
+
+// 其他消费者未确认的消息超过idle后被认领 不受XPENDING单页数量限制
+func TestStreamSourceClaimBeyondFirstPage(t *testing.T) {
+	dir := setupTestConfig(t)
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.jpg"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := miniredis.RunT(t)
+	conn := testRespConn(t, m)
+	source := newTestStreamSource(t, m, "stream-claim")
+
+	now := time.Now()
+	m.SetTime(now)
+
+	if _, err := conn.Do("XGROUP", "CREATE", "sync_files", "jzRedisRsync", "0", "MKSTREAM"); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := make([]string, 0)
+	for i := 0; i < 155; i++ {
+		reply, err := conn.Do("XADD", "sync_files", "*", "uri", "a.jpg", "dest", "cdn", "n", fmt.Sprint(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, reply.(string))
+	}
+
+	if _, err := conn.Do("XREADGROUP", "GROUP", "jzRedisRsync", "crashed", "COUNT", "155", "STREAMS", "sync_files", ">"); err != nil {
+		t.Fatal(err)
+	}
+
+	//前150条仍在其他消费者处理中 只有最后5条超时
+	m.SetTime(now.Add(time.Minute * 2))
+	if _, err := conn.Do(append([]string{"XCLAIM", "sync_files", "jzRedisRsync", "busy", "0"}, ids[:150]...)...); err != nil {
+		t.Fatal(err)
+	}
+
+	tasks, err := source.GetTasks(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer forgetTasks(tasks)
+
+	if len(tasks) != 5 {
+		t.Fatalf("expect 5 claimed tasks, got %d", len(tasks))
+	}
+
+	for i, task := range tasks {
+		if streamId := source.entries[task.Id]; streamId != ids[150+i] {
+			t.Errorf("task %d claimed entry %s, expect %s", i, streamId, ids[150+i])
+		}
+	}
+}
+
+func TestStreamNextId(t *testing.T) {
+	cases := map[string]string{
+		"1526569495631-0": "1526569495631-1",
+		"1526569495631-9": "1526569495631-10",
+		"bad":             "",
+		"1-x":             "",
+	}
+
+	for id, expect := range cases {
+		if next := streamNextId(id); next != expect {
+			t.Errorf("streamNextId(%s) = %s, expect %s", id, next, expect)
+		}
+	}
+}
+
+// 投递次数达到deliveries的消息写入dead后确认 不再认领
+func TestStreamSourceDeadLetter(t *testing.T) {
+	dir := setupTestConfig(t)
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.jpg"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := miniredis.RunT(t)
+	conn := testRespConn(t, m)
+	source := newTestStreamSource(t, m, "stream-dead")
+
+	now := time.Now()
+	m.SetTime(now)
+
+	if _, err := conn.Do("XGROUP", "CREATE", "sync_files", "jzRedisRsync", "0", "MKSTREAM"); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := make([]string, 0)
+	for i := 0; i < 2; i++ {
+		reply, err := conn.Do("XADD", "sync_files", "*", "uri", "a.jpg", "dest", "cdn")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, reply.(string))
+	}
+
+	if _, err := conn.Do("XREADGROUP", "GROUP", "jzRedisRsync", "crashed", "COUNT", "2", "STREAMS", "sync_files", ">"); err != nil {
+		t.Fatal(err)
+	}
+
+	//第一条已投递10次 超时后不再认领
+	if _, err := conn.Do("XCLAIM", "sync_files", "jzRedisRsync", "crashed", "0", ids[0], "RETRYCOUNT", "10"); err != nil {
+		t.Fatal(err)
+	}
+
+	m.SetTime(now.Add(time.Minute * 2))
+
+	tasks, err := source.GetTasks(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer forgetTasks(tasks)
+
+	if len(tasks) != 1 || source.entries[tasks[0].Id] != ids[1] {
+		t.Fatalf("expect %s claimed, got %d tasks", ids[1], len(tasks))
+	}
+
+	if n := testPendingCount(t, conn); n != 1 {
+		t.Fatalf("expect dead entry acked, got %d pending", n)
+	}
+
+	reply, err := conn.Do("XRANGE", "sync_files:dead", "-", "+")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dead, _ := reply.([]interface{})
+	if len(dead) != 1 {
+		t.Fatalf("expect 1 dead entry, got %v", reply)
+	}
+
+	if _, fields, _ := streamEntry(dead[0]); fields["entry"] != ids[0] || fields["deliveries"] != "10" || fields["uri"] != "a.jpg" {
+		t.Errorf("error dead entry %v", fields)
+	}
+}
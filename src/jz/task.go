@@ -1,6 +1,22 @@
 package jz
 
-import "path"
+import (
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type JzTargetResult struct {
+	Target   string `json:"target"`
+	Status   int    `json:"status"`
+	Attempts int    `json:"attempts"`
+	Bytes    int64  `json:"bytes"`
+	Error    string `json:"error"`
+	Finished int64  `json:"finished"`
+}
 
 type JzTask struct {
 	Id int
@@ -13,48 +29,251 @@ type JzTask struct {
 	HostNames []string
 	ExpectFinishedNum int
 	RsyncMaxNum int
+	Priority int
+	Source TaskSource
+	DoneTargets []string
+	Results map[string]*JzTargetResult
+	State string
+	Created int64
+	Started int64
+	Finished int64
+	aborted int32
+	completed chan bool
+	completeOnce sync.Once
+	resultLock sync.Mutex
 }
 
-func (obj *JzTask) Done(num int)  {
-	if obj.Id <= 0 {
-		return
+func (obj *JzTask) Key() string {
+	if obj.Source == nil {
+		return fmt.Sprintf("%d", obj.Id)
 	}
 
+	return TaskKey(obj.Source.Name(), obj.Id)
+}
+
+func (obj *JzTask) Done(num int)  {
 	status := 500
 	if obj.ExpectFinishedNum * len(obj.HostNames) <= num {
 		status = 200
+	} else if obj.succeeded() > 0 || len(obj.DoneTargets) > 0 {
+		status = 206
 	}
 
-	n, err := JzDaoInstance().UpdateTask(obj.Id, status)
+	switch status {
+	case 200:
+		obj.SetState(TASK_DONE)
+	case 206:
+		obj.SetState(TASK_PARTIAL)
+	default:
+		obj.SetState(TASK_FAILED)
+	}
+
+	if obj.Id <= 0 || obj.Source == nil {
+		return
+	}
+
+	n, err := obj.Source.UpdateTask(obj.Id, status)
 	if err == nil {
-		JzLogger.Printf("update task %d success status=%d,affectedRows=%d", obj.Id, status, n)
+		JzLogger.Printf("update task %s success status=%d,affectedRows=%d", obj.Key(), status, n)
 	} else {
-		JzLogger.Printf("update task %d failed", obj.Id)
+		JzLogger.Printf("update task %s failed %v", obj.Key(), err)
+	}
+}
+
+// 记录单个目标的同步结果 来源支持时同时回写
+func (obj *JzTask) Report(target string, ok bool, attempts int, bytes int64, err error) {
+	result := &JzTargetResult{
+		Target:   target,
+		Status:   200,
+		Attempts: attempts,
+		Bytes:    bytes,
+		Finished: time.Now().Unix(),
+	}
+
+	if !ok {
+		result.Status = 500
+		if err != nil {
+			result.Error = err.Error()
+		}
+	}
+
+	obj.resultLock.Lock()
+	if obj.Results == nil {
+		obj.Results = make(map[string]*JzTargetResult)
+	}
+	obj.Results[target] = result
+	obj.resultLock.Unlock()
+
+	if obj.Id <= 0 || obj.Source == nil {
+		return
+	}
+
+	if reporter, ok := obj.Source.(TargetReporter); ok {
+		if err := reporter.ReportTarget(obj.Id, result); err != nil {
+			JzLogger.Printf("report task %s target %s failed %v", obj.Key(), target, err)
+		}
+	}
+}
+
+func (obj *JzTask) succeeded() int {
+	obj.resultLock.Lock()
+	defer obj.resultLock.Unlock()
+
+	n := 0
+	for _, r := range obj.Results {
+		if r.Status == 200 {
+			n++
+		}
+	}
+
+	return n
+}
+
+// 本次同步成功的目标
+func (obj *JzTask) succeededTargets() []string {
+	obj.resultLock.Lock()
+	defer obj.resultLock.Unlock()
+
+	result := make([]string, 0)
+	for name, r := range obj.Results {
+		if r.Status == 200 && !InStringArray(name, obj.DoneTargets) {
+			result = append(result, name)
+		}
+	}
+
+	return result
+}
+
+func (obj *JzTask) SetState(state string) {
+	obj.resultLock.Lock()
+	defer obj.resultLock.Unlock()
+
+	obj.State = state
+	switch state {
+	case TASK_PENDING, TASK_QUEUED:
+		if obj.Created == 0 {
+			obj.Created = time.Now().Unix()
+		}
+	case TASK_RUNNING, TASK_PAUSED:
+		obj.Started = time.Now().Unix()
+	default:
+		obj.Finished = time.Now().Unix()
 	}
 }
 
+func (obj *JzTask) CurrentState() string {
+	obj.resultLock.Lock()
+	defer obj.resultLock.Unlock()
+
+	return obj.State
+}
+
+// 任务结束 唤醒等待者
+func (obj *JzTask) Complete() {
+	obj.completeOnce.Do(func() {
+		close(obj.completed)
+	})
+}
+
+// 等待任务结束 timeout为0时一直等待 超时返回false
+func (obj *JzTask) Wait(timeout time.Duration) bool {
+	if timeout <= 0 {
+		<-obj.completed
+		return true
+	}
+
+	select {
+	case <-obj.completed:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// 中止同步中的任务 当前目标同步结束后不再同步其余目标
+func (obj *JzTask) Abort() {
+	atomic.StoreInt32(&obj.aborted, 1)
+}
+
+func (obj *JzTask) Aborted() bool {
+	return atomic.LoadInt32(&obj.aborted) == 1
+}
+
+// 当前状态及各目标同步结果
+func (obj *JzTask) Status() *JzTaskStatus {
+	obj.resultLock.Lock()
+	defer obj.resultLock.Unlock()
+
+	status := &JzTaskStatus{
+		Id:       obj.Key(),
+		File:     AuditPath(path.Join(obj.RelativePath, obj.Name)),
+		Size:     obj.Size,
+		Md5:      obj.M5Sum,
+		Targets:  obj.HostNames,
+		Priority: obj.Priority,
+		State:    obj.State,
+		Created:  obj.Created,
+		Started:  obj.Started,
+		Finished: obj.Finished,
+		Results:  make([]*JzTargetResult, 0, len(obj.Results)),
+	}
+
+	for _, r := range obj.Results {
+		status.Bytes += r.Bytes
+		status.Results = append(status.Results, r)
+	}
+
+	sort.Slice(status.Results, func(i, j int) bool {
+		return status.Results[i].Target < status.Results[j].Target
+	})
+
+	return status
+}
+
 func (obj *JzTask) Cancel(status int)  {
-	if obj.Id <= 0 {
+	if obj.Id <= 0 || obj.Source == nil {
 		return
 	}
 
-	n, err := JzDaoInstance().UpdateTask(obj.Id, status)
+	n, err := obj.Source.UpdateTask(obj.Id, status)
 	if err == nil {
-		JzLogger.Printf("update task %d success status=%d,affectedRows=%d", obj.Id, status, n)
+		JzLogger.Printf("update task %s success status=%d,affectedRows=%d", obj.Key(), status, n)
 	} else {
-		JzLogger.Printf("update task %d failed", obj.Id)
+		JzLogger.Printf("update task %s failed %v", obj.Key(), err)
 	}
 }
 
 func AssembleTask(id int, file string) (*JzTask, error) {
-	taskPath := path.Join(jzRsyncConfig.Repertory, file)
-	n,err := GetFileSize(taskPath)
+	task, err := NewFileTask(id, file)
 	if err != nil {
-		JzLogger.Print(err)
 		return nil, err
 	}
 
-	md5sum, err := GetFileMD5sum(taskPath)
+	err = task.Checksum()
+	if err != nil {
+		return nil, err
+	}
+
+	return task, nil
+}
+
+// 计算文件md5
+func (obj *JzTask) Checksum() error {
+	md5sum, err := GetFileMD5sum(obj.Path)
+	if err != nil {
+		JzLogger.Print(err)
+		return err
+	}
+
+	obj.M5Sum = md5sum
+
+	return nil
+}
+
+// 只读取文件大小的任务 md5需另行计算
+func NewFileTask(id int, file string) (*JzTask, error) {
+	taskPath := path.Join(RsyncConfig().Repertory, file)
+	n,err := GetFileSize(taskPath)
 	if err != nil {
 		JzLogger.Print(err)
 		return nil, err
@@ -73,11 +292,11 @@ func AssembleTask(id int, file string) (*JzTask, error) {
 		Name:taskName,
 		Size:n,
 		Path: taskPath,
-		M5Sum:md5sum,
 		AbsolutePath: taskDir,
 		RelativePath: taskRelativePath,
 		HostNames:[]string{},
-		ExpectFinishedNum:len(jzRsyncConfig.TargetServer),
+		ExpectFinishedNum:len(RsyncConfig().TargetServer),
 		RsyncMaxNum:3,
+		completed:make(chan bool),
 	}, nil
 }
\ No newline at end of file
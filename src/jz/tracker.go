@@ -0,0 +1,231 @@
+package jz
+
+import (
+	"container/list"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// 任务状态
+const (
+	TASK_SCHEDULED = "scheduled"
+	TASK_PENDING   = "pending"
+	TASK_QUEUED    = "queued"
+	TASK_RUNNING   = "running"
+	TASK_PAUSED    = "paused"
+	TASK_DONE      = "done"
+	TASK_PARTIAL   = "partial"
+	TASK_FAILED    = "failed"
+	TASK_NOTFOUND  = "notfound"
+	TASK_DEAD      = "dead"
+	TASK_CANCELLED = "cancelled"
+)
+
+type JzTaskStatus struct {
+	Id       string            `json:"id"`
+	File     string            `json:"file"`
+	Size     int64             `json:"size"`
+	Md5      string            `json:"md5"`
+	Targets  []string          `json:"targets"`
+	Priority int               `json:"priority"`
+	State    string            `json:"state"`
+	Created  int64             `json:"created"`
+	Started  int64             `json:"started"`
+	Finished int64             `json:"finished"`
+	Bytes    int64             `json:"bytes"`
+	Results  []*JzTargetResult `json:"results"`
+}
+
+// 批量提交中单个文件的受理结果
+type JzBatchFile struct {
+	File  string `json:"file"`
+	Id    string `json:"id,omitempty"`
+	State string `json:"state,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// 可按id查询任务状态的任务来源 用于查询不在内存中的任务
+type TaskInspector interface {
+	InspectTask(id int) (*JzTaskStatus, error)
+}
+
+// 记录队列中及同步中的任务 已结束的任务最多保留limit个供查询
+type JzTaskTracker struct {
+	sync.Mutex
+	tasks    map[string]*JzTask
+	finished *list.List
+	limit    int
+	seq      int
+	batches  map[string][]*JzBatchFile
+	batchIds *list.List
+	batchSeq int
+	aliases  map[string]string
+	aliasIds *list.List
+}
+
+func NewJzTaskTracker(limit int) *JzTaskTracker {
+	return &JzTaskTracker{
+		tasks:    make(map[string]*JzTask),
+		finished: list.New(),
+		limit:    limit,
+		batches:  make(map[string][]*JzBatchFile),
+		batchIds: list.New(),
+		aliases:  make(map[string]string),
+		aliasIds: list.New(),
+	}
+}
+
+// 为没有来源的任务分配id
+func (obj *JzTaskTracker) NextId() int {
+	obj.Lock()
+	defer obj.Unlock()
+
+	obj.seq++
+	return obj.seq
+}
+
+func (obj *JzTaskTracker) Track(t *JzTask) {
+	obj.Lock()
+	defer obj.Unlock()
+
+	obj.tasks[t.Key()] = t
+}
+
+func (obj *JzTaskTracker) Finish(t *JzTask) {
+	t.Complete()
+
+	obj.Lock()
+	defer obj.Unlock()
+
+	obj.finished.PushBack(t)
+	for obj.finished.Len() > obj.limit {
+		e := obj.finished.Front()
+		old := obj.finished.Remove(e).(*JzTask)
+		//同一任务重试后会被新的任务替换
+		if obj.tasks[old.Key()] == old {
+			delete(obj.tasks, old.Key())
+		}
+	}
+}
+
+func (obj *JzTaskTracker) Get(key string) *JzTask {
+	obj.Lock()
+	defer obj.Unlock()
+
+	if k, ok := obj.aliases[key]; ok {
+		key = k
+	}
+
+	return obj.tasks[key]
+}
+
+// 定时任务到期提交后 以定时任务id查询实际的任务 最多保留limit个
+func (obj *JzTaskTracker) Alias(alias string, key string) {
+	obj.Lock()
+	defer obj.Unlock()
+
+	obj.aliases[alias] = key
+	obj.aliasIds.PushBack(alias)
+
+	for obj.aliasIds.Len() > obj.limit {
+		delete(obj.aliases, obj.aliasIds.Remove(obj.aliasIds.Front()).(string))
+	}
+}
+
+func (obj *JzTaskTracker) Resolve(key string) string {
+	obj.Lock()
+	defer obj.Unlock()
+
+	if k, ok := obj.aliases[key]; ok {
+		return k
+	}
+
+	return key
+}
+
+// 记录批量提交结果 最多保留1000个批次
+func (obj *JzTaskTracker) AddBatch(files []*JzBatchFile) string {
+	obj.Lock()
+	defer obj.Unlock()
+
+	obj.batchSeq++
+	id := fmt.Sprintf("batch:%d", obj.batchSeq)
+	obj.batches[id] = files
+	obj.batchIds.PushBack(id)
+
+	for obj.batchIds.Len() > 1000 {
+		delete(obj.batches, obj.batchIds.Remove(obj.batchIds.Front()).(string))
+	}
+
+	return id
+}
+
+// 批次中每个文件的受理结果 已受理的文件附带当前任务状态
+func (obj *JzTaskTracker) Batch(id string) []*JzBatchFile {
+	obj.Lock()
+	files, ok := obj.batches[id]
+	tasks := make([]*JzTask, len(files))
+	for i, f := range files {
+		tasks[i] = obj.tasks[f.Id]
+	}
+	obj.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	result := make([]*JzBatchFile, len(files))
+	for i, f := range files {
+		r := *f
+		if tasks[i] != nil {
+			r.State = tasks[i].CurrentState()
+		}
+		result[i] = &r
+	}
+
+	return result
+}
+
+// 是否有未结束的任务来自该来源
+func (obj *JzTaskTracker) Using(source TaskSource) bool {
+	obj.Lock()
+	tasks := make([]*JzTask, 0)
+	for _, t := range obj.tasks {
+		if SameSource(t.Source, source) {
+			tasks = append(tasks, t)
+		}
+	}
+	obj.Unlock()
+
+	for _, t := range tasks {
+		if state := t.CurrentState(); state == TASK_QUEUED || state == TASK_RUNNING || state == TASK_PAUSED {
+			return true
+		}
+	}
+
+	return false
+}
+
+// 同步中的任务状态 按开始时间排序
+func (obj *JzTaskTracker) Running() []*JzTaskStatus {
+	obj.Lock()
+	tasks := make([]*JzTask, 0, len(obj.tasks))
+	for _, t := range obj.tasks {
+		tasks = append(tasks, t)
+	}
+	obj.Unlock()
+
+	result := make([]*JzTaskStatus, 0)
+	for _, t := range tasks {
+		if status := t.Status(); status.State == TASK_RUNNING {
+			result = append(result, status)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Started < result[j].Started
+	})
+
+	return result
+}
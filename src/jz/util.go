@@ -8,6 +8,10 @@ import (
 	"io"
 	"encoding/hex"
 	"strings"
+	"path"
+	"math/rand"
+	"io/ioutil"
+	"path/filepath"
 )
 
 func CheckFileIsDirectory(path string) (bool, error)  {
@@ -75,4 +79,97 @@ func HasIntersection(a []string, b []string) bool  {
 	}
 
 	return false
-}
\ No newline at end of file
+}
+
+// 按/分段匹配路径 **匹配任意层目录 其余规则同path.Match
+func MatchPath(pattern string, name string) bool {
+	return matchPathSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchPathSegments(pattern []string, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(name); i++ {
+			if matchPathSegments(pattern[1:], name[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(name) == 0 {
+		return false
+	}
+
+	ok, err := path.Match(pattern[0], name[0])
+	if err != nil || !ok {
+		return false
+	}
+
+	return matchPathSegments(pattern[1:], name[1:])
+}
+
+func IsGlobPattern(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// 展开root下匹配pattern的文件 返回相对root的路径
+func GlobFiles(root string, pattern string) ([]string, error) {
+	pattern = strings.TrimPrefix(path.Clean("/"+pattern), "/")
+
+	//从第一个含通配符的目录开始遍历
+	segments := strings.Split(pattern, "/")
+	i := 0
+	for i < len(segments)-1 && !IsGlobPattern(segments[i]) {
+		i++
+	}
+
+	result := make([]string, 0)
+	err := filepath.Walk(filepath.Join(root, strings.Join(segments[:i], "/")), func(p string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return nil
+		}
+
+		rel = filepath.ToSlash(rel)
+		if MatchPath(pattern, rel) {
+			result = append(result, rel)
+		}
+
+		return nil
+	})
+
+	return result, err
+}
+
+// 第n次失败后的重试间隔秒数 指数增长不超过maxDelay 并在后一半区间内随机
+func RetryDelay(n int, base int, maxDelay int) int {
+	delay := base
+	for i := 1; i < n && delay < maxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+
+	return delay/2 + rand.Intn(delay/2+1)
+}
+
+// 先写临时文件再重命名 避免崩溃时留下不完整的文件
+func WriteFileAtomic(file string, data []byte) error {
+	tmp := file + ".tmp"
+	err := ioutil.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, file)
+}
@@ -0,0 +1,105 @@
+package jz
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRetryDelay(t *testing.T) {
+	cases := []struct {
+		n        int
+		base     int
+		maxDelay int
+		expect   int
+	}{
+		{1, 30, 3600, 30},
+		{2, 30, 3600, 60},
+		{3, 30, 3600, 120},
+		{8, 30, 3600, 3600},
+		{100, 30, 3600, 3600},
+		{1, 60, 40, 40},
+		{0, 30, 3600, 30},
+	}
+
+	for _, c := range cases {
+		for i := 0; i < 50; i++ {
+			delay := RetryDelay(c.n, c.base, c.maxDelay)
+			if delay < c.expect/2 || delay > c.expect {
+				t.Fatalf("RetryDelay(%d, %d, %d) = %d, expect in [%d, %d]", c.n, c.base, c.maxDelay, delay, c.expect/2, c.expect)
+			}
+		}
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	cases := []struct {
+		pattern string
+		name    string
+		expect  bool
+	}{
+		{"a/b.jpg", "a/b.jpg", true},
+		{"a/*.jpg", "a/b.jpg", true},
+		{"a/*.jpg", "a/c/b.jpg", false},
+		{"a/**/*.jpg", "a/b.jpg", true},
+		{"a/**/*.jpg", "a/c/d/b.jpg", true},
+		{"a/**/*.jpg", "b/c/b.jpg", false},
+		{"**", "a/b/c", true},
+		{"**/b.jpg", "b.jpg", true},
+		{"a/**", "a", true},
+		{"a/?.jpg", "a/bc.jpg", false},
+		{"a/[bc].jpg", "a/c.jpg", true},
+		{"a/[.jpg", "a/[.jpg", false},
+		{"", "", true},
+		{"a", "", false},
+	}
+
+	for _, c := range cases {
+		if ok := MatchPath(c.pattern, c.name); ok != c.expect {
+			t.Errorf("MatchPath(%q, %q) = %v, expect %v", c.pattern, c.name, ok, c.expect)
+		}
+	}
+}
+
+func TestGlobFiles(t *testing.T) {
+	dir := setupTestConfig(t)
+
+	files := []string{"a.jpg", "b.png", "img/2026/01/c.webp", "img/2026/02/d.webp", "img/2026/e.jpg", "img/x.webp"}
+	for _, f := range files {
+		p := filepath.Join(dir, f)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte("hello"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		pattern string
+		expect  []string
+	}{
+		{"*.jpg", []string{"a.jpg"}},
+		{"/*.jpg", []string{"a.jpg"}},
+		{"img/2026/**/*.webp", []string{"img/2026/01/c.webp", "img/2026/02/d.webp"}},
+		{"img/**", []string{"img/2026/01/c.webp", "img/2026/02/d.webp", "img/2026/e.jpg", "img/x.webp"}},
+		{"**/*.jpg", []string{"a.jpg", "img/2026/e.jpg"}},
+		{"img/*/0?/*.webp", []string{"img/2026/01/c.webp", "img/2026/02/d.webp"}},
+		{"../**/*.jpg", []string{"a.jpg", "img/2026/e.jpg"}},
+		{"none/*.jpg", []string{}},
+	}
+
+	for _, c := range cases {
+		matches, err := GlobFiles(dir, c.pattern)
+		if err != nil {
+			t.Errorf("GlobFiles(%s) error %v", c.pattern, err)
+			continue
+		}
+
+		if fmt.Sprint(matches) != fmt.Sprint(c.expect) {
+			t.Errorf("GlobFiles(%s) = %v, expect %v", c.pattern, matches, c.expect)
+		}
+	}
+}
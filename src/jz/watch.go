@@ -0,0 +1,236 @@
+package jz
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type jzWatchFile struct {
+	lastEvent time.Time
+	size      int64
+	modTime   time.Time
+}
+
+// 监听repertory目录 文件写入完成并稳定后按规则推送到目标group
+type JzWatchSource struct {
+	sync.Mutex
+	name    string
+	root    string
+	delay   time.Duration
+	rules   []JzWatchRule
+	watcher *fsnotify.Watcher
+	pending map[string]*jzWatchFile
+	stopped chan bool
+}
+
+func init() {
+	RegisterTaskSource("watch", NewJzWatchSource)
+}
+
+func NewJzWatchSource(config *JzSourceConfig) (TaskSource, error) {
+	if len(config.Rules) == 0 {
+		return nil, errors.New(fmt.Sprintf("task source %s watch rules is required", config.Name))
+	}
+
+	delay := config.Delay
+	if delay <= 0 {
+		delay = 3
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	return &JzWatchSource{
+		name:    config.Name,
+		root:    RsyncConfig().Repertory,
+		delay:   time.Second * time.Duration(delay),
+		rules:   config.Rules,
+		watcher: watcher,
+		pending: make(map[string]*jzWatchFile),
+		stopped: make(chan bool),
+	}, nil
+}
+
+func (obj *JzWatchSource) Name() string {
+	return obj.name
+}
+
+// 文件变化由Watch主动推送
+func (obj *JzWatchSource) GetTasks(limit int) ([]*JzTask, error) {
+	return nil, nil
+}
+
+func (obj *JzWatchSource) UpdateTask(id int, status int) (int64, error) {
+	return 0, nil
+}
+
+func (obj *JzWatchSource) Close() {
+	close(obj.stopped)
+	obj.watcher.Close()
+	JzLogger.Printf("watch %s closed", obj.name)
+}
+
+func (obj *JzWatchSource) addRecursive(dir string) {
+	filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		if !fi.IsDir() {
+			//监听前已存在的文件不处理 新建目录中的文件按新文件处理
+			if dir != obj.root {
+				obj.touch(p)
+			}
+			return nil
+		}
+
+		if p != obj.root && strings.HasPrefix(fi.Name(), ".") {
+			return filepath.SkipDir
+		}
+
+		if err := obj.watcher.Add(p); err != nil {
+			JzLogger.Printf("watch %s folder %s failed %v", obj.name, p, err)
+		}
+
+		return nil
+	})
+}
+
+func (obj *JzWatchSource) touch(p string) {
+	if strings.HasPrefix(filepath.Base(p), ".") {
+		return
+	}
+
+	obj.Lock()
+	defer obj.Unlock()
+
+	f, ok := obj.pending[p]
+	if !ok {
+		f = &jzWatchFile{size: -1}
+		obj.pending[p] = f
+	}
+	f.lastEvent = time.Now()
+}
+
+func (obj *JzWatchSource) Watch(rsync *JzRsync) {
+	obj.addRecursive(obj.root)
+
+	JzLogger.Printf("watch %s start at %s", obj.name, obj.root)
+
+	go func() {
+		interval := time.NewTicker(time.Second)
+		defer interval.Stop()
+
+	W:
+		for {
+			select {
+			case <-obj.stopped:
+				break W
+			case event, ok := <-obj.watcher.Events:
+				if !ok {
+					break W
+				}
+
+				if event.Op&fsnotify.Create == fsnotify.Create {
+					fi, err := os.Stat(event.Name)
+					if err == nil && fi.IsDir() {
+						obj.addRecursive(event.Name)
+						continue
+					}
+				}
+
+				if event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Chmod) != 0 {
+					obj.touch(event.Name)
+				} else if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+					obj.Lock()
+					delete(obj.pending, event.Name)
+					obj.Unlock()
+				}
+			case err, ok := <-obj.watcher.Errors:
+				if !ok {
+					break W
+				}
+				JzLogger.Printf("watch %s error %v", obj.name, err)
+			case <-interval.C:
+				for _, p := range obj.stableFiles() {
+					obj.send(rsync, p)
+				}
+			}
+		}
+
+		JzLogger.Printf("watch %s exit", obj.name)
+	}()
+}
+
+// 超过delay没有变化且两次检查大小及修改时间一致视为写入完成
+func (obj *JzWatchSource) stableFiles() []string {
+	obj.Lock()
+	defer obj.Unlock()
+
+	result := make([]string, 0)
+	now := time.Now()
+
+	for p, f := range obj.pending {
+		if now.Sub(f.lastEvent) < obj.delay {
+			continue
+		}
+
+		fi, err := os.Stat(p)
+		if err != nil || fi.IsDir() {
+			delete(obj.pending, p)
+			continue
+		}
+
+		if fi.Size() != f.size || !fi.ModTime().Equal(f.modTime) {
+			f.size = fi.Size()
+			f.modTime = fi.ModTime()
+			f.lastEvent = now
+			continue
+		}
+
+		delete(obj.pending, p)
+		result = append(result, p)
+	}
+
+	return result
+}
+
+func (obj *JzWatchSource) send(rsync *JzRsync, p string) {
+	rel, err := filepath.Rel(obj.root, p)
+	if err != nil {
+		return
+	}
+	rel = filepath.ToSlash(rel)
+
+	hostNames := make([]string, 0)
+	for _, rule := range obj.rules {
+		if MatchPath(rule.Match, rel) {
+			hostNames = append(hostNames, strings.Split(strings.ToUpper(rule.Dest), ",")...)
+		}
+	}
+
+	if len(hostNames) == 0 {
+		JzLogger.Printf("watch %s file %s not match any rule", obj.name, rel)
+		return
+	}
+
+	task, err := AssembleTask(0, rel)
+	if err != nil || task.Size == 0 {
+		JzLogger.Printf("watch %s assemble task file %s failed %v", obj.name, rel, err)
+		return
+	}
+
+	task.HostNames = append(task.HostNames, hostNames...)
+
+	JzLogger.Print("got task from watch", task)
+
+	rsync.Send(task)
+}
@@ -8,6 +8,7 @@ import (
 )
 
 var optionConfigFile = flag.String("config", "./config.xml", "configure xml file")
+var optionSql = flag.String("sql", "", "print NOTIFY trigger sql of the postgres task source")
 
 func usage() {
 	fmt.Printf("Usage: %s [options]Options:", os.Args[0])
@@ -29,5 +30,16 @@ func main() {
 		os.Exit(1)
 	}
 
+	//按配置生成postgres触发器
+	if len(*optionSql) > 0 {
+		sql, err := jz.PostgresNotifySql(*optionSql)
+		if err != nil {
+			jz.JzLogger.Print(err)
+			os.Exit(1)
+		}
+		fmt.Print(sql)
+		os.Exit(0)
+	}
+
 	jz.Run()
 }